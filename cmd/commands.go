@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/oscarbc96/agbridge/pkg/proxy"
+	"github.com/samber/lo"
+	"github.com/spf13/afero"
+)
+
+const programName = "agbridge"
+
+// command is an agbridge subcommand with its own flags, help and examples.
+type command struct {
+	name    string
+	summary string
+	// usage follows the command name in the usage line.
+	usage string
+	// examples are printed in the help, %[1]s is replaced by the program name.
+	examples string
+	flags    flagGroup
+	// register adds the flags specific to the command, and returns the
+	// function validating and copying their values to Flags once parsed.
+	register func(fset *flag.FlagSet) func(flags *Flags) error
+	// args validates the positional arguments, the command accepts none when
+	// nil.
+	args func(args []string) error
+	run  func(fs afero.Fs, flags *Flags)
+}
+
+var (
+	serveCommand = &command{
+		name:    "serve",
+		summary: "Starts the proxy, the default command when none is given.",
+		usage:   "[flags]",
+		examples: `  # Show version
+  %[1]s --version
+
+  # Use a specific config file
+  %[1]s serve --config=config.yaml
+
+  # Set profile name with a Rest API ID
+  %[1]s serve --profile-name=myprofile --rest-api-id=12345 --region=eu-west-1
+
+  # Serve two Rest APIs under their own path prefixes, without a config file
+  %[1]s serve --gateway id=12345,stage=prod,prefix=/orders --gateway id=67890,stage=prod,prefix=/users
+
+  # Set log level to debug
+  %[1]s serve --log-level=debug
+
+  # Write JSON logs to a rotated file and an access log to stdout
+  %[1]s serve --log-format=json --log-file=agbridge.log --access-log=-
+
+  # Set the listen address for the proxy server
+  %[1]s serve --listen-address=:9090
+
+  # Listen on a TCP port and a Unix domain socket
+  %[1]s serve --listen-address=:9090,unix:///tmp/agbridge.sock
+
+  # Answer requests with 503 at once until the routes are loaded
+  %[1]s serve --load-wait=0
+
+  # Listen on a free port and write its URL to a ready file
+  %[1]s serve --listen-address=127.0.0.1:0 --ready-file=agbridge.json
+
+  # Serve HTTPS with a certificate issued by a local CA
+  %[1]s serve --tls-self-signed --tls-hostnames=localhost,agbridge.local
+
+  # Serve HTTPS with your own certificate and require client certificates
+  %[1]s serve --tls-cert=cert.pem --tls-key=key.pem --tls-client-ca=clients.pem
+
+  # Export traces to a local OpenTelemetry collector
+  %[1]s serve --otlp-endpoint=http://localhost:4318
+
+  # Use the default config file (agbridge.yaml or agbridge.yml if they exist)
+  %[1]s
+`,
+		flags: gatewayFlags | serverFlags,
+		run:   runServe,
+	}
+
+	routesCommand = &command{
+		name:    "routes",
+		summary: "Prints the routes of the gateways without starting the proxy.",
+		usage:   "[flags]",
+		examples: `  # Print the routes of the default config file
+  %[1]s routes
+
+  # Print the routes of a Rest API
+  %[1]s routes --rest-api-id=12345 --region=eu-west-1
+
+  # Write the route inventory as JSON to a file
+  %[1]s routes --output=json --output-file=routes.json
+`,
+		flags: gatewayFlags,
+		register: func(fset *flag.FlagSet) func(flags *Flags) error {
+			output := fset.String("output", "table", "Sets the output format. Options: table, json, yaml, csv, markdown.")
+			outputFile := fset.String("output-file", "", "Writes the routes to this file instead of stdout.")
+			return func(flags *Flags) error {
+				format, err := proxy.ParseRouteFormat(*output)
+				if err != nil {
+					return err
+				}
+				flags.Output, flags.OutputFile = string(format), *outputFile
+				return nil
+			}
+		},
+		run: runRoutes,
+	}
+
+	invokeCommand = &command{
+		name:    "invoke",
+		summary: "Sends a single request through the routes of the gateways and prints the response.",
+		usage:   "[flags] <path>",
+		examples: `  # Call a route of the default config file
+  %[1]s invoke /dev/users/42?expand=orders
+
+  # Delete a resource of a Rest API
+  %[1]s invoke --rest-api-id=12345 -X DELETE /users/42
+
+  # Send a JSON body read from a file, or from stdin with @-
+  %[1]s invoke -X POST /dev/orders -H 'Content-Type: application/json' -d @body.json
+
+  # Print the API Gateway execution log and the response as JSON
+  %[1]s invoke --show-log --output=json /dev/users/42
+`,
+		flags: gatewayFlags,
+		register: func(fset *flag.FlagSet) func(flags *Flags) error {
+			method := fset.String("X", "", "HTTP method of the request (default GET, or POST with -d).")
+			var headers []string
+			fset.Func("H", "Header of the request, as 'Name: value'. Repeat it to send several.", func(value string) error {
+				if _, _, ok := strings.Cut(value, ":"); !ok {
+					return fmt.Errorf("invalid header %q: must be 'Name: value'", value)
+				}
+				headers = append(headers, value)
+				return nil
+			})
+			data := fset.String("d", "", "Body of the request, or @file to read it from a file and @- from stdin.")
+			showLog := fset.Bool("show-log", false, "Prints the API Gateway execution log to stderr, or adds it to the JSON output.")
+			output := fset.String("output", "text", "Sets the output format. Options: text, json.")
+			return func(flags *Flags) error {
+				flags.Method = strings.ToUpper(*method)
+				if !validMethod(flags.Method) {
+					return fmt.Errorf("invalid method %q: must only contain letters, digits and !#$%%&'*+-.^_`|~", *method)
+				}
+				if flags.Method == "" {
+					flags.Method = lo.Ternary(*data != "", "POST", "GET")
+				}
+				flags.Headers = headers
+				flags.Data = *data
+				flags.ShowLog = *showLog
+				flags.Output = *output
+				if !lo.Contains([]string{"text", "json"}, flags.Output) {
+					return errors.New("invalid output format: must be one of text, json")
+				}
+				return nil
+			}
+		},
+		args: func(args []string) error {
+			if len(args) != 1 {
+				return errors.New("`invoke` requires a single <path> argument")
+			}
+			if !strings.HasPrefix(args[0], "/") {
+				return fmt.Errorf("path %s must start with /", args[0])
+			}
+			return nil
+		},
+		run: runInvoke,
+	}
+
+	validateCommand = &command{
+		name:    "validate",
+		summary: "Checks the credentials, Rest API, stage, permissions and routes of every gateway.",
+		usage:   "[flags]",
+		examples: `  # Validate the default config file
+  %[1]s validate
+
+  # Validate a specific config file
+  %[1]s validate --config=config.yaml
+`,
+		flags: gatewayFlags,
+		run:   runValidate,
+	}
+
+	execCommand = &command{
+		name:    "exec",
+		summary: "Runs a command with the proxy up and exits with its status.",
+		usage:   "[flags] -- <command> [args...]",
+		examples: `  # Run the tests against the gateways of a config file
+  %[1]s exec --config=config.yaml -- go test ./...
+
+  # Call a Rest API from a script, through its URL variable
+  %[1]s exec --rest-api-id=12345 --stage-name=dev -- sh -c 'curl "$AGBRIDGE_URL_12345/users"'
+`,
+		flags: gatewayFlags,
+		args: func(args []string) error {
+			if len(args) == 0 {
+				return errors.New("`exec` requires a <command> argument")
+			}
+			return nil
+		},
+		run: runExec,
+	}
+
+	discoverCommand = &command{
+		name:    "discover",
+		summary: "Lists the Rest APIs and stages of an account and region.",
+		usage:   "[flags]",
+		examples: `  # List the Rest APIs of the default profile and region
+  %[1]s discover
+
+  # List the Rest APIs of a profile in a region
+  %[1]s discover --profile-name=myprofile --region=eu-west-1
+`,
+		register: func(fset *flag.FlagSet) func(flags *Flags) error {
+			profileName := fset.String("profile-name", "", "Specifies the profile name.")
+			region := fset.String("region", "", "Specifies the AWS region.")
+			return func(flags *Flags) error {
+				flags.ProfileName, flags.Region = *profileName, *region
+				return nil
+			}
+		},
+		run: runDiscover,
+	}
+
+	configPrintCommand = &command{
+		name:    "config print",
+		summary: "Prints the merged configuration and where every value came from.",
+		usage:   "[flags]",
+		examples: `  # Print the configuration merged from the config files, environment and flags
+  %[1]s config print
+
+  # Print it with a gateway overridden by flags
+  %[1]s config print --rest-api-id=12345 --stage-name=dev
+`,
+		flags: gatewayFlags | serverFlags,
+		run:   runConfigPrint,
+	}
+
+	commands = []*command{serveCommand, routesCommand, invokeCommand, validateCommand, execCommand, discoverCommand, configPrintCommand}
+)
+
+// findCommand returns the command named by the first argument and the rest of
+// the arguments, serve when the first argument is a flag or there are none.
+// It returns nil when the command doesn't exist or help was requested.
+func findCommand(args []string) (*command, []string) {
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && !isHelpFlag(args[0])) {
+		return serveCommand, args
+	}
+
+	if args[0] == "help" && len(args) > 1 {
+		return findCommand(append(append([]string(nil), args[1:]...), "--help"))
+	}
+
+	// Names of several words, like config print, match as many arguments
+	cmd, ok := lo.Find(commands, func(cmd *command) bool {
+		words := strings.Fields(cmd.name)
+		return len(args) >= len(words) && slices.Equal(args[:len(words)], words)
+	})
+	if !ok {
+		return nil, args
+	}
+	return cmd, args[len(strings.Fields(cmd.name)):]
+}
+
+// validMethod reports whether the method is empty or an HTTP token.
+func validMethod(method string) bool {
+	return strings.IndexFunc(method, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r))
+	}) < 0
+}
+
+func isHelpFlag(arg string) bool {
+	return lo.Contains([]string{"-h", "-help", "--help"}, arg)
+}
+
+func (c *command) validateArgs(args []string) error {
+	if c.args != nil {
+		return c.args(args)
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected argument %s", args[0])
+	}
+	return nil
+}
+
+func (c *command) printUsage(fset *flag.FlagSet) {
+	out := fset.Output()
+	fmt.Fprintf(out, "Usage: %s %s %s\n\n%s\n\nFlags:\n", programName, c.name, c.usage, c.summary)
+	fset.PrintDefaults()
+	fmt.Fprintf(out, "\nExamples:\n"+c.examples, programName)
+}
+
+// printCommands prints the usage listing every command.
+func printCommands(out io.Writer) {
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", programName)
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nRun '%s <command> --help' for the flags and examples of a command.\n", programName)
+}
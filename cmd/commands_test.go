@@ -0,0 +1,158 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindCommand(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		args    []string
+		expCmd  *command
+		expArgs []string
+	}{
+		{name: "No arguments", args: []string{}, expCmd: serveCommand, expArgs: []string{}},
+		{name: "Flags only", args: []string{"--rest-api-id", "12345"}, expCmd: serveCommand, expArgs: []string{"--rest-api-id", "12345"}},
+		{name: "Command", args: []string{"routes", "--config", "config.yaml"}, expCmd: routesCommand, expArgs: []string{"--config", "config.yaml"}},
+		{name: "Help of command", args: []string{"help", "invoke"}, expCmd: invokeCommand, expArgs: []string{"--help"}},
+		{name: "Command of several words", args: []string{"config", "print", "--config", "config.yaml"}, expCmd: configPrintCommand, expArgs: []string{"--config", "config.yaml"}},
+		{name: "Help of command of several words", args: []string{"help", "config", "print"}, expCmd: configPrintCommand, expArgs: []string{"--help"}},
+		{name: "Incomplete command", args: []string{"config"}, expArgs: []string{"config"}},
+		{name: "Help", args: []string{"--help"}, expArgs: []string{"--help"}},
+		{name: "Unknown command", args: []string{"nope"}, expArgs: []string{"nope"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cmd, args := findCommand(tt.args)
+			assert.Equal(t, tt.expCmd, cmd)
+			assert.Equal(t, tt.expArgs, args)
+		})
+	}
+}
+
+func TestParseCommandFlags(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		cmd    *command
+		args   []string
+		expErr string
+		check  func(t *testing.T, flags *Flags)
+	}{
+		{
+			name: "Invoke",
+			cmd:  invokeCommand,
+			args: []string{"--rest-api-id", "12345", "-X", "post", "/users"},
+			check: func(t *testing.T, flags *Flags) {
+				assert.Equal(t, "POST", flags.Method)
+				assert.Equal(t, []string{"/users"}, flags.Args)
+				assert.Nil(t, flags.ListenAddresses)
+			},
+		},
+		{
+			name: "Invoke with data",
+			cmd:  invokeCommand,
+			args: []string{"--rest-api-id", "12345", "-H", "Content-Type: application/json", "-d", "@body.json", "--output", "json", "/orders"},
+			check: func(t *testing.T, flags *Flags) {
+				assert.Equal(t, "POST", flags.Method)
+				assert.Equal(t, []string{"Content-Type: application/json"}, flags.Headers)
+				assert.Equal(t, "@body.json", flags.Data)
+				assert.Equal(t, "json", flags.Output)
+			},
+		},
+		{
+			name:   "Invoke with invalid header",
+			cmd:    invokeCommand,
+			args:   []string{"--rest-api-id", "12345", "-H", "Content-Type", "/orders"},
+			expErr: `invalid value "Content-Type" for flag -H: invalid header "Content-Type": must be 'Name: value'`,
+		},
+		{
+			name:   "Invoke with invalid method",
+			cmd:    invokeCommand,
+			args:   []string{"--rest-api-id", "12345", "-X", "GET /x HTTP/1.1", "/orders"},
+			expErr: `invalid method "GET /x HTTP/1.1": must only contain letters, digits and !#$%&'*+-.^_` + "`|~",
+		},
+		{
+			name:   "Invoke with invalid output",
+			cmd:    invokeCommand,
+			args:   []string{"--rest-api-id", "12345", "--output", "xml", "/orders"},
+			expErr: "invalid output format: must be one of text, json",
+		},
+		{
+			name:   "Invoke without path",
+			cmd:    invokeCommand,
+			args:   []string{"--rest-api-id", "12345"},
+			expErr: "`invoke` requires a single <path> argument",
+		},
+		{
+			name:   "Routes with argument",
+			cmd:    routesCommand,
+			args:   []string{"--rest-api-id", "12345", "extra"},
+			expErr: "unexpected argument extra",
+		},
+		{
+			name:   "Routes with server flag",
+			cmd:    routesCommand,
+			args:   []string{"--listen-address", ":9090"},
+			expErr: "flag provided but not defined: -listen-address",
+		},
+		{
+			name: "Exec",
+			cmd:  execCommand,
+			args: []string{"--rest-api-id", "12345", "--", "go", "test", "-run", "TestAPI", "./..."},
+			check: func(t *testing.T, flags *Flags) {
+				assert.Equal(t, []string{"go", "test", "-run", "TestAPI", "./..."}, flags.Args)
+				assert.Nil(t, flags.ListenAddresses)
+			},
+		},
+		{
+			name:   "Exec without command",
+			cmd:    execCommand,
+			args:   []string{"--rest-api-id", "12345", "--"},
+			expErr: "`exec` requires a <command> argument",
+		},
+		{
+			name: "Discover without config",
+			cmd:  discoverCommand,
+			args: []string{"--profile-name", "dev", "--region", "eu-west-1"},
+			check: func(t *testing.T, flags *Flags) {
+				assert.Equal(t, "dev", flags.ProfileName)
+				assert.Equal(t, "eu-west-1", flags.Region)
+				assert.Empty(t, flags.Config)
+			},
+		},
+		{
+			name: "Config print",
+			cmd:  configPrintCommand,
+			args: []string{"--rest-api-id", "12345", "--log-level", "debug"},
+			check: func(t *testing.T, flags *Flags) {
+				assert.Contains(t, flags.Settings, Setting{Name: "log-level", Value: "debug", Source: "flag"})
+				assert.Contains(t, flags.Settings, Setting{Name: "listen-address", Value: ":8080", Source: "default"})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			flags, err := tt.cmd.parseFlags(afero.NewMemMapFs(), tt.args)
+			if tt.expErr != "" {
+				require.EqualError(t, err, tt.expErr)
+				return
+			}
+			require.NoError(t, err)
+			tt.check(t, flags)
+		})
+	}
+}
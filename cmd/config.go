@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/oscarbc96/agbridge/pkg/proxy"
+	"github.com/samber/lo"
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// SystemConfigFile is the first configuration file merged, when it exists.
+	SystemConfigFile = "/etc/agbridge/config.yaml"
+	// EnvPrefix prefixes the environment variables setting flags, like
+	// AGBRIDGE_LOG_LEVEL for --log-level.
+	EnvPrefix = "AGBRIDGE_"
+
+	sourceDefault = "default"
+	sourceFlag    = "flag"
+)
+
+// gatewaySelectionFlags select the gateways and can't be set in config files,
+// which list the gateways instead.
+var gatewaySelectionFlags = []string{"config", "gateway", "profile-name", "rest-api-id", "region", "stage-name"}
+
+// Setting is the value of a flag and where it came from: the default, a
+// config file, an environment variable or the command line.
+type Setting struct {
+	Name   string
+	Value  string
+	Source string
+}
+
+// userConfigFile returns the configuration file of the user, empty when the
+// user config directory is unknown.
+func userConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "agbridge", "config.yaml")
+}
+
+// defaultConfigFile returns the project config file in the working directory,
+// empty when there is none.
+func defaultConfigFile(fs afero.Fs) string {
+	configFile, _ := lo.Find([]string{DefaultConfigFileYml, DefaultConfigFileYaml}, func(name string) bool {
+		_, err := fs.Stat(name)
+		return err == nil
+	})
+	return configFile
+}
+
+// configFiles returns the config files that exist, from the lowest to the
+// highest precedence: the system, user and project files.
+func configFiles(fs afero.Fs, project string) []string {
+	return lo.Filter([]string{SystemConfigFile, userConfigFile(), project}, func(name string, _ int) bool {
+		if name == "" {
+			return false
+		}
+		_, err := fs.Stat(name)
+		return err == nil
+	})
+}
+
+// mergeConfigFiles merges the config files, the value of the last file
+// defining a key wins. Sections like auth or cache are merged key by key,
+// while lists like gateways replace those of the files before. It returns the
+// merged mapping and the files every top-level key came from.
+func mergeConfigFiles(fs afero.Fs, files []string) (*yaml.Node, map[string]string, error) {
+	merged := &yaml.Node{Kind: yaml.MappingNode}
+	sources := make(map[string]string)
+	index := make(map[string]int)
+
+	for _, filename := range files {
+		data, err := afero.ReadFile(fs, filename)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read config file %s: %w", filename, err)
+		}
+
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse config file %s: %w", filename, err)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		root := doc.Content[0]
+		if root.Kind != yaml.MappingNode {
+			return nil, nil, fmt.Errorf("failed to parse config file %s: must be a mapping", filename)
+		}
+		resolvePaths(root, filepath.Dir(filename))
+
+		for i := 0; i < len(root.Content); i += 2 {
+			key, value := root.Content[i], root.Content[i+1]
+			j, ok := index[key.Value]
+			if !ok {
+				index[key.Value] = len(merged.Content)
+				merged.Content = append(merged.Content, key, value)
+				sources[key.Value] = filename
+				continue
+			}
+
+			if merged.Content[j+1].Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+				sources[key.Value] += ", " + filename
+			} else {
+				sources[key.Value] = filename
+			}
+			merged.Content[j+1] = mergeNodes(merged.Content[j+1], value)
+		}
+	}
+
+	return merged, sources, nil
+}
+
+// mergeNodes merges the keys of two mappings, recursively. Any other value
+// replaces the previous one.
+func mergeNodes(previous, value *yaml.Node) *yaml.Node {
+	if previous.Kind != yaml.MappingNode || value.Kind != yaml.MappingNode {
+		return value
+	}
+
+	merged := &yaml.Node{Kind: yaml.MappingNode, Content: slices.Clone(previous.Content)}
+	for i := 0; i < len(value.Content); i += 2 {
+		key := value.Content[i].Value
+		if current := mappingValue(merged, key); current != nil {
+			setMappingValue(merged, key, mergeNodes(current, value.Content[i+1]))
+			continue
+		}
+		merged.Content = append(merged.Content, value.Content[i], value.Content[i+1])
+	}
+	return merged
+}
+
+// resolvePaths makes the mock body files of a config file relative to its
+// directory, so they are found once merged with other files.
+func resolvePaths(root *yaml.Node, dir string) {
+	for i := 0; i < len(root.Content); i += 2 {
+		if root.Content[i].Value != "mocks" || root.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, mock := range root.Content[i+1].Content {
+			if mock.Kind != yaml.MappingNode {
+				continue
+			}
+			for j := 0; j < len(mock.Content); j += 2 {
+				value := mock.Content[j+1]
+				if mock.Content[j].Value == "body_file" && value.Kind == yaml.ScalarNode && value.Value != "" && !filepath.IsAbs(value.Value) {
+					value.Value = filepath.Join(dir, value.Value)
+				}
+			}
+		}
+	}
+}
+
+func settingKey(flagName string) string {
+	return strings.ReplaceAll(flagName, "-", "_")
+}
+
+func envName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(settingKey(flagName))
+}
+
+// settingValue returns the flag value of a setting in a config file, lists
+// are joined with commas.
+func settingValue(node *yaml.Node) (string, bool) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Value, true
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", false
+			}
+			values = append(values, item.Value)
+		}
+		return strings.Join(values, ","), true
+	default:
+		return "", false
+	}
+}
+
+// applyEnv sets the flags without a source from their AGBRIDGE_*
+// environment variable.
+func applyEnv(fset *flag.FlagSet, names []string, sources map[string]string) error {
+	for _, name := range names {
+		if _, ok := sources[name]; ok {
+			continue
+		}
+		value, ok := os.LookupEnv(envName(name))
+		if !ok {
+			continue
+		}
+		if err := fset.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %w", value, envName(name), err)
+		}
+		sources[name] = "env " + envName(name)
+	}
+	return nil
+}
+
+// applyConfigFiles sets the flags without a source from the top-level keys
+// of the config files, except the flags selecting gateways.
+func applyConfigFiles(fs afero.Fs, fset *flag.FlagSet, names []string, files []string, sources map[string]string) error {
+	merged, keySources, err := mergeConfigFiles(fs, files)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(merged.Content); i += 2 {
+		key, node := merged.Content[i].Value, merged.Content[i+1]
+		name := strings.ReplaceAll(key, "_", "-")
+		if !lo.Contains(names, name) || lo.Contains(gatewaySelectionFlags, name) {
+			continue
+		}
+		if _, ok := sources[name]; ok {
+			continue
+		}
+
+		value, ok := settingValue(node)
+		if !ok {
+			return fmt.Errorf("invalid %s in %s: must be a value or a list of values", key, keySources[key])
+		}
+		if err := fset.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s in %s: %w", key, keySources[key], err)
+		}
+		sources[name] = keySources[key]
+	}
+	return nil
+}
+
+// loadLayeredConfig merges the config files and applies the gateway flags. It
+// returns the configuration and where every top-level key came from.
+func loadLayeredConfig(fs afero.Fs, flags *Flags) (*proxy.Config, map[string]string, error) {
+	files := configFiles(fs, flags.Config)
+
+	merged, sources, err := mergeConfigFiles(fs, files)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Gateway flags are applied before reading the configuration, so they are
+	// validated like the gateways of the files
+	applied, err := applyGatewayFlags(merged, flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	if applied {
+		sources["gateways"] = strings.Join(lo.Compact([]string{sources["gateways"], sourceFlag}), ", ")
+	}
+
+	var buf bytes.Buffer
+	if err := yaml.NewEncoder(&buf).Encode(merged); err != nil {
+		return nil, nil, err
+	}
+
+	log.Info("Loading configuration", log.Any("files", files))
+	// Relative paths were resolved against their file while merging
+	cfg, err := proxy.ReadConfig(fs, &buf, ".")
+	if err != nil {
+		if len(files) == 0 {
+			return nil, nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+		return nil, nil, fmt.Errorf("failed to load config files %s: %w", strings.Join(files, ", "), err)
+	}
+
+	return cfg, sources, nil
+}
+
+// appendGateways appends the gateways to those of the merged config files.
+func appendGateways(merged *yaml.Node, gateways []proxy.GatewayConfig) error {
+	// Go through JSON, which omits the empty fields
+	data, err := json.Marshal(gateways)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	node := doc.Content[0]
+
+	if current := mappingValue(merged, "gateways"); current != nil && current.Kind == yaml.SequenceNode {
+		current.Content = append(current.Content, node.Content...)
+		return nil
+	}
+	setMappingValue(merged, "gateways", node)
+	return nil
+}
+
+// mappingValue returns the value of the key in a mapping node, nil when it
+// doesn't have it.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue replaces the value of the key in a mapping node, or adds it.
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+// applyGatewayFlags appends the gateways of --gateway to the merged config
+// files, then adds the gateway of --rest-api-id, or overrides it when they
+// have it. Without --rest-api-id, --profile-name, --region and --stage-name
+// override every gateway. It reports whether a flag was applied.
+func applyGatewayFlags(merged *yaml.Node, flags *Flags) (bool, error) {
+	applied := flags.Gateways != nil
+	if applied {
+		if err := appendGateways(merged, flags.Gateways); err != nil {
+			return false, err
+		}
+	}
+
+	overrides := lo.OmitByValues(map[string]string{
+		"profile_name": flags.ProfileName,
+		"region":       flags.Region,
+		"stage_name":   flags.StageName,
+	}, []string{""})
+	if flags.RestAPIID == "" && len(overrides) == 0 {
+		return applied, nil
+	}
+
+	var gateways []*yaml.Node
+	if node := mappingValue(merged, "gateways"); node != nil && node.Kind == yaml.SequenceNode {
+		gateways = lo.Filter(node.Content, func(gw *yaml.Node, _ int) bool { return gw.Kind == yaml.MappingNode })
+	}
+	if flags.RestAPIID != "" {
+		gateways = lo.Filter(gateways, func(gw *yaml.Node, _ int) bool {
+			id := mappingValue(gw, "rest_api_id")
+			return id != nil && id.Value == flags.RestAPIID
+		})
+		if len(gateways) == 0 {
+			return true, appendGateways(merged, proxy.NewConfig(flags.RestAPIID, flags.ProfileName, flags.Region, flags.StageName).Gateways)
+		}
+	}
+
+	for _, gw := range gateways {
+		for key, value := range overrides {
+			setMappingValue(gw, key, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+		}
+	}
+	return true, nil
+}
+
+// printConfig prints the settings and the configuration as YAML, with the
+// source of every top-level key in a comment. Secrets are redacted.
+func printConfig(out io.Writer, settings []Setting, cfg *proxy.Config, sources map[string]string) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+
+	for _, setting := range settings {
+		value := &yaml.Node{Kind: yaml.ScalarNode, Value: setting.Value}
+		if setting.Value == "" {
+			value.Style = yaml.DoubleQuotedStyle
+		}
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: settingKey(setting.Name), LineComment: setting.Source},
+			value,
+		)
+	}
+
+	// Go through JSON, which omits the empty fields of the configuration
+	data, err := json.Marshal(cfg.Redacted())
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	config := doc.Content[0]
+	for i := 0; i < len(config.Content); i += 2 {
+		key := config.Content[i]
+		source, ok := sources[key.Value]
+		if !ok {
+			continue
+		}
+		value := config.Content[i+1]
+		resetStyle(key)
+		resetStyle(value)
+		if value.Style == yaml.FlowStyle {
+			value.LineComment = source
+		} else {
+			key.LineComment = source
+		}
+		root.Content = append(root.Content, key, value)
+	}
+
+	encoder := yaml.NewEncoder(out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// resetStyle drops the flow style and quotes of the nodes parsed from JSON,
+// keeping them where needed for empty values.
+func resetStyle(node *yaml.Node) {
+	switch {
+	case node.Kind == yaml.ScalarNode && node.Tag == "!!str" && node.Value == "":
+	case node.Kind != yaml.ScalarNode && len(node.Content) == 0:
+	default:
+		node.Style = 0
+	}
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
+
+func runConfigPrint(fs afero.Fs, flags *Flags) {
+	cfg, sources, err := loadLayeredConfig(fs, flags)
+	if err != nil {
+		log.Fatal("Failed to load configuration", log.Err(err))
+	}
+
+	if err := printConfig(os.Stdout, flags.Settings, cfg, sources); err != nil {
+		log.Fatal("Failed to print configuration", log.Err(err))
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/oscarbc96/agbridge/pkg/auth"
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/oscarbc96/agbridge/pkg/proxy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMergeConfigFiles(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "system.yaml", []byte("log_level: warn\nlisten_address: :9090\n"), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "empty.yaml", []byte(""), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "project.yaml", []byte("log_level: debug\ngateways:\n  - rest_api_id: abc\n"), 0o644))
+
+	merged, sources, err := mergeConfigFiles(fs, []string{"system.yaml", "empty.yaml", "project.yaml"})
+	require.NoError(t, err)
+
+	keys := make([]string, 0, len(merged.Content)/2)
+	for i := 0; i < len(merged.Content); i += 2 {
+		keys = append(keys, merged.Content[i].Value)
+	}
+	assert.Equal(t, []string{"log_level", "listen_address", "gateways"}, keys)
+	assert.Equal(t, "debug", merged.Content[1].Value)
+	assert.Equal(t, map[string]string{"log_level": "project.yaml", "listen_address": "system.yaml", "gateways": "project.yaml"}, sources)
+
+	require.NoError(t, afero.WriteFile(fs, "list.yaml", []byte("- abc\n"), 0o644))
+	_, _, err = mergeConfigFiles(fs, []string{"list.yaml"})
+	require.EqualError(t, err, "failed to parse config file list.yaml: must be a mapping")
+}
+
+func TestMergeConfigFilesSections(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	system := "auth:\n  tokens:\n    - name: ci\n      token: system\n  jwt:\n    jwks_url: https://issuer.example.com/jwks.json\n    issuer: system\ncache:\n  ttl: 30s\n"
+	project := "auth:\n  basic:\n    - username: alice\n      password: project\n  jwt:\n    issuer: project\ncache:\n  ttl: 1m\n  max_entries: 10\n"
+	require.NoError(t, afero.WriteFile(fs, SystemConfigFile, []byte(system), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "project.yaml", []byte(project), 0o644))
+
+	cfg, sources, err := loadLayeredConfig(fs, &Flags{Config: "project.yaml", RestAPIID: "abc"})
+	require.NoError(t, err)
+
+	// Sections are merged key by key, lists are replaced
+	assert.Equal(t, []auth.TokenConfig{{Name: "ci", Token: "system"}}, cfg.Auth.Tokens)
+	assert.Equal(t, []auth.BasicConfig{{Username: "alice", Password: "project"}}, cfg.Auth.Basic)
+	assert.Equal(t, &auth.JWTConfig{JWKSURL: "https://issuer.example.com/jwks.json", Issuer: "project"}, cfg.Auth.JWT)
+	assert.Equal(t, time.Minute, cfg.Cache.TTL)
+	assert.Equal(t, 10, cfg.Cache.MaxEntries)
+	assert.Equal(t, SystemConfigFile+", project.yaml", sources["auth"])
+}
+
+func TestMergeConfigFilesRelativePaths(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/etc/agbridge/mocks/health.json", []byte(`{"status": "ok"}`), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "/etc/agbridge/config.yaml", []byte("mocks:\n  - path: /health\n    body_file: mocks/health.json\n"), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "project/agbridge.yaml", []byte("gateways:\n  - rest_api_id: abc\n"), 0o644))
+
+	cfg, _, err := loadLayeredConfig(fs, &Flags{Config: "project/agbridge.yaml"})
+	require.NoError(t, err)
+	require.Len(t, cfg.Mocks, 1)
+	assert.Equal(t, "/etc/agbridge/mocks/health.json", cfg.Mocks[0].BodyFile)
+}
+
+func TestParseFlagsFromEnv(t *testing.T) {
+	t.Setenv("AGBRIDGE_LOG_LEVEL", "debug")
+	t.Setenv("AGBRIDGE_LISTEN_ADDRESS", ":9090,:9091")
+	t.Setenv("AGBRIDGE_LOG_FORMAT", "json")
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, DefaultConfigFileYaml, []byte("log_level: warn\nlog_max_size: 5\ngateways: []\n"), 0o644))
+
+	flags, err := parseFlags(fs, []string{"--log-format", "logfmt"})
+	require.NoError(t, err)
+
+	// Environment variables override the config files, and flags override both
+	assert.Equal(t, log.LevelDebug, flags.LogLevel)
+	assert.Equal(t, log.FormatLogfmt, flags.LogFormat)
+	assert.Equal(t, 5, flags.LogMaxSize)
+	assert.Equal(t, []string{":9090", ":9091"}, flags.ListenAddresses)
+
+	t.Setenv("AGBRIDGE_LOG_MAX_SIZE", "big")
+	_, err = parseFlags(fs, nil)
+	require.EqualError(t, err, `invalid value "big" for AGBRIDGE_LOG_MAX_SIZE: parse error`)
+}
+
+func TestLoadLayeredConfigGateways(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "config.yaml", []byte("gateways:\n  - rest_api_id: abc\n"), 0o644))
+
+	flags := &Flags{Config: "config.yaml", Gateways: []proxy.GatewayConfig{{RestAPIID: "def", StageName: "prod", PathPrefix: "/orders"}}}
+	cfg, sources, err := loadLayeredConfig(fs, flags)
+	require.NoError(t, err)
+	assert.Equal(t, []proxy.GatewayConfig{{RestAPIID: "abc"}, {RestAPIID: "def", StageName: "prod", PathPrefix: "/orders"}}, cfg.Gateways)
+	assert.Equal(t, "config.yaml, flag", sources["gateways"])
+
+	// Gateways given with flags are validated like those of the files
+	flags = &Flags{Gateways: []proxy.GatewayConfig{{RestAPIID: "def", PathPrefix: "orders"}}}
+	_, _, err = loadLayeredConfig(fs, flags)
+	require.ErrorContains(t, err, `invalid path_prefix "orders" for Rest API ID def`)
+}
+
+func TestApplyGatewayFlags(t *testing.T) {
+	t.Parallel()
+
+	gateways := func() *proxy.Config {
+		return &proxy.Config{Gateways: []proxy.GatewayConfig{
+			{RestAPIID: "abc", StageName: "prod"},
+			{RestAPIID: "def", Region: "us-east-1", StageName: "prod"},
+		}}
+	}
+	config := "gateways:\n  - rest_api_id: abc\n    stage_name: prod\n  - rest_api_id: def\n    region: us-east-1\n    stage_name: prod\n"
+
+	tests := []struct {
+		name    string
+		flags   Flags
+		applied bool
+		exp     []proxy.GatewayConfig
+	}{
+		{
+			name: "No gateway flags",
+			exp:  gateways().Gateways,
+		},
+		{
+			name:    "Override every gateway",
+			flags:   Flags{Region: "eu-west-1", StageName: "dev"},
+			applied: true,
+			exp: []proxy.GatewayConfig{
+				{RestAPIID: "abc", Region: "eu-west-1", StageName: "dev"},
+				{RestAPIID: "def", Region: "eu-west-1", StageName: "dev"},
+			},
+		},
+		{
+			name:    "Override a gateway",
+			flags:   Flags{RestAPIID: "def", ProfileName: "staging", StageName: "dev"},
+			applied: true,
+			exp: []proxy.GatewayConfig{
+				{RestAPIID: "abc", StageName: "prod"},
+				{RestAPIID: "def", ProfileName: "staging", Region: "us-east-1", StageName: "dev"},
+			},
+		},
+		{
+			name:    "Add a gateway",
+			flags:   Flags{RestAPIID: "ghi", StageName: "dev"},
+			applied: true,
+			exp: append(gateways().Gateways,
+				proxy.GatewayConfig{RestAPIID: "ghi", StageName: "dev"},
+			),
+		},
+		{
+			name:    "Override a gateway of --gateway",
+			flags:   Flags{Gateways: []proxy.GatewayConfig{{RestAPIID: "ghi", StageName: "prod"}}, RestAPIID: "ghi", StageName: "dev"},
+			applied: true,
+			exp: append(gateways().Gateways,
+				proxy.GatewayConfig{RestAPIID: "ghi", StageName: "dev"},
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var doc yaml.Node
+			require.NoError(t, yaml.Unmarshal([]byte(config), &doc))
+			merged := doc.Content[0]
+
+			applied, err := applyGatewayFlags(merged, &tt.flags)
+			require.NoError(t, err)
+			assert.Equal(t, tt.applied, applied)
+
+			data, err := yaml.Marshal(merged)
+			require.NoError(t, err)
+			cfg, err := proxy.ReadConfig(afero.NewMemMapFs(), bytes.NewReader(data), ".")
+			require.NoError(t, err)
+			assert.Equal(t, tt.exp, cfg.Gateways)
+		})
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	t.Parallel()
+
+	settings := []Setting{
+		{Name: "log-level", Value: "debug", Source: "env AGBRIDGE_LOG_LEVEL"},
+		{Name: "route-cache", Value: "", Source: "default"},
+	}
+	cfg := &proxy.Config{
+		Gateways:         []proxy.GatewayConfig{{RestAPIID: "abc", StageName: "dev"}},
+		FallbackUpstream: "http://localhost:3000",
+	}
+	sources := map[string]string{"gateways": "agbridge.yaml, flag"}
+
+	var out bytes.Buffer
+	require.NoError(t, printConfig(&out, settings, cfg, sources))
+
+	assert.Equal(t, `log_level: debug # env AGBRIDGE_LOG_LEVEL
+route_cache: "" # default
+gateways: # agbridge.yaml, flag
+  - rest_api_id: abc
+    stage_name: dev
+`, out.String())
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigateway/types"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/oscarbc96/agbridge/pkg/awsutils"
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/samber/lo"
+	"github.com/spf13/afero"
+)
+
+func runDiscover(_ afero.Fs, flags *Flags) {
+	awsCfg, err := awsutils.LoadConfigFor(flags.ProfileName, flags.Region)
+	if err != nil {
+		log.Fatal("Failed to load AWS config", log.Err(err))
+	}
+
+	apis, err := awsutils.ListRestAPIs(*awsCfg)
+	if err != nil {
+		log.Fatal("Failed to discover Rest APIs", log.Err(err))
+	}
+	sort.Slice(apis, func(i, j int) bool { return aws.ToString(apis[i].Name) < aws.ToString(apis[j].Name) })
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Rest API ID", "Name", "Endpoint Type", "Stages", "Description"})
+	t.SetColumnConfigs([]table.ColumnConfig{{Name: "Description", WidthMax: 60}})
+
+	for _, api := range apis {
+		stages, err := awsutils.ListStages(*awsCfg, aws.ToString(api.Id))
+		if err != nil {
+			log.Fatal("Failed to list stages", log.Err(err), log.String("rest_api_id", aws.ToString(api.Id)))
+		}
+
+		var endpointTypes []types.EndpointType
+		if api.EndpointConfiguration != nil {
+			endpointTypes = api.EndpointConfiguration.Types
+		}
+
+		t.AppendRow(table.Row{
+			aws.ToString(api.Id),
+			aws.ToString(api.Name),
+			strings.Join(lo.Map(endpointTypes, func(t types.EndpointType, _ int) string { return string(t) }), ", "),
+			strings.Join(lo.Map(stages, func(s types.Stage, _ int) string { return aws.ToString(s.StageName) }), ", "),
+			aws.ToString(api.Description),
+		})
+	}
+
+	t.SetStyle(table.StyleLight)
+	t.Render()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/oscarbc96/agbridge/pkg/proxy"
+	"github.com/spf13/afero"
+)
+
+// execListenAddress binds the proxy of exec to an ephemeral loopback port.
+const execListenAddress = "127.0.0.1:0"
+
+// execEnv returns the variables exported to the command run by exec: the URL
+// of the proxy and of every gateway, as AGBRIDGE_URL_<REST API ID>, or
+// AGBRIDGE_URL_<REST API ID>_<STAGE> when several gateways share the API.
+func execEnv(proxyURL string, gateways []proxy.GatewayStatus, gatewayURLs []string) ([]string, error) {
+	shared := make(map[string]int, len(gateways))
+	for i, gw := range gateways {
+		if gatewayURLs[i] != "" {
+			shared[gw.RestAPIID]++
+		}
+	}
+
+	env := []string{"AGBRIDGE_URL=" + proxyURL}
+	names := make(map[string]bool, len(gateways))
+	for i, gw := range gateways {
+		if gatewayURLs[i] == "" {
+			continue
+		}
+
+		name := "AGBRIDGE_URL_" + envSuffix(gw.RestAPIID)
+		if shared[gw.RestAPIID] > 1 {
+			name += "_" + envSuffix(gw.StageName)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("several gateways of Rest API ID %s would export %s, use a different stage for each", gw.RestAPIID, name)
+		}
+		names[name] = true
+
+		env = append(env, name+"="+gatewayURLs[i])
+	}
+	return env, nil
+}
+
+// envSuffix turns s into the end of an environment variable name, in upper
+// case and with underscores instead of any other character.
+func envSuffix(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// interactive reports whether agbridge runs in a terminal, which delivers the
+// interrupts of Ctrl-C to the command as well, as it shares the foreground
+// process group.
+func interactive() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// exitCode returns the exit code of a command that ran with the given error,
+// 128 plus the signal number when a signal killed it.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 1
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+func runExec(fs afero.Fs, flags *Flags) {
+	server := newProxy(fs, flags, []string{execListenAddress})
+
+	if err := server.Reload(); err != nil {
+		log.Fatal("Configuration validation failed", log.Err(err))
+	}
+
+	if err := server.Listen(); err != nil {
+		log.Fatal("Failed to listen", log.Err(err))
+	}
+
+	go func() {
+		log.Info("Starting proxy server", log.Any("addresses", server.Addrs()))
+		if err := server.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Proxy server encountered an error", log.Err(err))
+		}
+	}()
+
+	cmd := exec.Command(flags.Args[0], flags.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	env, err := execEnv(server.URL(), server.Gateways(), server.GatewayURLs())
+	if err != nil {
+		log.Fatal("Failed to export the gateway URLs", log.Err(err))
+	}
+	cmd.Env = append(os.Environ(), env...)
+
+	// Forward the signals to the command instead of stopping agbridge, which
+	// stops once the command exits. Interrupts from a terminal already reach
+	// the command, forwarding them would interrupt it twice.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	code := 0
+	if err := cmd.Start(); err != nil {
+		log.Error("Failed to start command", log.Err(err), log.String("command", flags.Args[0]))
+		code = 127
+	} else {
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+
+	wait:
+		for {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGINT && interactive() {
+					continue
+				}
+				log.Debug("Forwarding signal to command", log.String("signal", sig.String()))
+				_ = cmd.Process.Signal(sig)
+			case err := <-done:
+				if err != nil {
+					code = exitCode(err)
+				}
+				break wait
+			}
+		}
+	}
+	signal.Stop(signals)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Error("Failed to stop proxy server gracefully", log.Err(err))
+	}
+	cancel()
+
+	os.Exit(code)
+}
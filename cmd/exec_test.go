@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/oscarbc96/agbridge/pkg/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecEnv(t *testing.T) {
+	t.Parallel()
+
+	gateways := []proxy.GatewayStatus{{RestAPIID: "abc123", StageName: "dev"}, {RestAPIID: "def456"}, {RestAPIID: "ghi789"}}
+	urls := []string{"http://127.0.0.1:4000/dev", "http://127.0.0.1:4001", ""}
+
+	env, err := execEnv("http://127.0.0.1:4000", gateways, urls)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"AGBRIDGE_URL=http://127.0.0.1:4000",
+		"AGBRIDGE_URL_ABC123=http://127.0.0.1:4000/dev",
+		"AGBRIDGE_URL_DEF456=http://127.0.0.1:4001",
+	}, env)
+
+	// Gateways sharing an API are told apart by their stage
+	gateways = []proxy.GatewayStatus{{RestAPIID: "abc123", StageName: "dev"}, {RestAPIID: "abc123", StageName: "prod-v2"}}
+	urls = []string{"http://127.0.0.1:4000/dev", "http://127.0.0.1:4000/prod-v2"}
+
+	env, err = execEnv("http://127.0.0.1:4000", gateways, urls)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"AGBRIDGE_URL=http://127.0.0.1:4000",
+		"AGBRIDGE_URL_ABC123_DEV=http://127.0.0.1:4000/dev",
+		"AGBRIDGE_URL_ABC123_PROD_V2=http://127.0.0.1:4000/prod-v2",
+	}, env)
+
+	gateways[1].StageName = "dev"
+	_, err = execEnv("http://127.0.0.1:4000", gateways, urls)
+	require.EqualError(t, err, "several gateways of Rest API ID abc123 would export AGBRIDGE_URL_ABC123_DEV, use a different stage for each")
+}
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 3, exitCode(exec.Command("sh", "-c", "exit 3").Run()))
+	assert.Equal(t, 143, exitCode(exec.Command("sh", "-c", "kill -TERM $$").Run()))
+	assert.Equal(t, 1, exitCode(exec.Command("agbridge-command-not-found").Run()))
+}
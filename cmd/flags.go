@@ -4,10 +4,13 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/oscarbc96/agbridge/pkg/proxy"
 	"github.com/samber/lo"
 	"github.com/spf13/afero"
 )
@@ -15,119 +18,398 @@ import (
 const (
 	DefaultConfigFileYaml = "agbridge.yaml"
 	DefaultConfigFileYml  = "agbridge.yml"
+	DefaultListenAddress  = ":8080"
+	DefaultLoadWait       = 10 * time.Second
+)
+
+// flagGroup selects the flags accepted by a command, besides the logging
+// ones accepted by all of them.
+type flagGroup int
+
+const (
+	// gatewayFlags select the gateways with --config or --rest-api-id.
+	gatewayFlags flagGroup = 1 << iota
+	// serverFlags configure the listeners, TLS, access log and tracing.
+	serverFlags
 )
 
 type Flags struct {
-	Config        string
-	ListenAddress string
-	LogLevel      log.Level
-	ProfileName   string
-	Region        string
-	RestAPIID     string
+	AccessLog       string
+	AccessLogFormat log.AccessFormat
+	// Args are the positional arguments of the command.
+	Args   []string
+	Config string
+	Data   string
+	// Gateways are the gateways given with --gateway.
+	Gateways        []proxy.GatewayConfig
+	Headers         []string
+	ListenAddresses []string
+	LoadWait        time.Duration
+	LogFile         string
+	LogFormat       log.Format
+	LogLevel        log.Level
+	LogMaxSize      int
+	Method          string
+	Offline         bool
+	OTLPEndpoint    string
+	Output          string
+	OutputFile      string
+	ProfileName     string
+	ReadyFile       string
+	Region          string
+	RestAPIID       string
+	RouteCache      string
+	// Settings are the values of the flags shared by the commands and where
+	// they came from, printed by `config print`.
+	Settings      []Setting
+	ShowLog       bool
 	StageName     string
+	TLSCert       string
+	TLSClientCA   string
+	TLSDir        string
+	TLSHostnames  []string
+	TLSKey        string
+	TLSSelfSigned bool
 	Version       bool
 }
 
+// parseFlags parses the flags of the serve command.
 func parseFlags(fs afero.Fs, args []string) (*Flags, error) {
-	fset := flag.NewFlagSet("agbridge", flag.ContinueOnError)
-
-	version := fset.Bool("version", false, "Displays the application version and exits.")
-	config := fset.String("config", "", "Specifies the path to a configuration file (cannot be used with --profile-name, --rest-api-id, --region or --stage-name).")
-	profileName := fset.String("profile-name", "", "Specifies the profile name (requires --rest-api-id and --region to be specified).")
-	restAPIID := fset.String("rest-api-id", "", "Specifies the Rest API ID (required if --config is not provided).")
-	region := fset.String("region", "", "Specifies the AWS region to use with --profile-name and --rest-api-id.")
-	stageName := fset.String("stage-name", "", "Specifies the stage name to use with --profile-name and --rest-api-id and --region.")
-	logLevelStr := fset.String("log-level", "info", "Sets the log verbosity level. Options: debug, info, warn, error, fatal.")
-	listenAddress := fset.String("listen-address", ":8080", "Address where the proxy server will listen for incoming requests.")
+	return serveCommand.parseFlags(fs, args)
+}
 
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage of %s:\n", args[0])
-		flag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, `
-Examples:
-  # Show version
-  %[1]s --version
+func (c *command) parseFlags(fs afero.Fs, args []string) (*Flags, error) {
+	fset := flag.NewFlagSet("agbridge "+c.name, flag.ContinueOnError)
+	fset.Usage = func() { c.printUsage(fset) }
 
-  # Use a specific config file
-  %[1]s --config=config.yaml
+	var version *bool
+	if c == serveCommand {
+		version = fset.Bool("version", false, "Displays the application version and exits.")
+	}
+
+	var config, profileName, restAPIID, region, stageName, routeCache *string
+	var offline *bool
+	gateways := &gatewaysFlag{}
+	if c.flags&gatewayFlags != 0 {
+		config = fset.String("config", "", "Specifies the path to the project configuration file (default agbridge.yaml or agbridge.yml), merged over the system and user config files.")
+		profileName = fset.String("profile-name", "", "Specifies the profile name of the --rest-api-id gateway, or of every configured gateway without it.")
+		restAPIID = fset.String("rest-api-id", "", "Specifies the Rest API ID of a gateway, added to the configured ones or overriding the one with the same ID.")
+		region = fset.String("region", "", "Specifies the AWS region of the --rest-api-id gateway, or of every configured gateway without it.")
+		stageName = fset.String("stage-name", "", "Specifies the stage name of the --rest-api-id gateway, or of every configured gateway without it.")
+		fset.Var(gateways, "gateway", "Adds a gateway as id=<rest api id>[,profile=<name>][,region=<region>][,stage=<name>][,prefix=<path>][,listen=<address>]. Repeat it to add several.")
+		routeCache = fset.String("route-cache", "", "Caches the routes of the gateways in this directory, starting from them and revalidating them in the background. Disabled when empty.")
+		offline = fset.Bool("offline", false, "Reads the routes only from --route-cache, without calling API Gateway to resolve them.")
+	}
 
-  # Set profile name with a Rest API ID
-  %[1]s --profile-name=myprofile --rest-api-id=12345
+	logLevelStr := fset.String("log-level", "info", "Sets the log verbosity level. Options: debug, info, warn, error, fatal.")
+	logFormatStr := fset.String("log-format", "console", "Sets the log output format. Options: console, json, logfmt.")
+	logFile := fset.String("log-file", "", "Writes logs to this file instead of stderr, rotating it by size.")
+	logMaxSize := fset.Int("log-max-size", 100, "Maximum size in megabytes of a log file before it is rotated.")
 
-  # Set log level to debug
-  %[1]s --log-level=debug
+	var accessLog, accessLogFormatStr, readyFile, tlsCert, tlsKey, tlsHostnames, tlsDir, tlsClientCA, otlpEndpoint *string
+	var tlsSelfSigned *bool
+	var loadWait *time.Duration
+	listenAddresses := &listFlag{}
+	if c.flags&serverFlags != 0 {
+		accessLog = fset.String("access-log", "", "Writes an access log line per request to this file, or to stdout with -. Disabled when empty.")
+		accessLogFormatStr = fset.String("access-log-format", "clf", "Sets the access log format. Options: clf, json.")
+		listenAddresses.values = []string{DefaultListenAddress}
+		fset.Var(listenAddresses, "listen-address", "Address where the proxy server will listen for incoming requests, as host:port or unix:///path.sock. Repeat it or separate addresses with commas to listen on several.")
+		loadWait = fset.Duration("load-wait", DefaultLoadWait, "How long requests received while the routes load wait for them, before being answered with 503 Service Unavailable.")
+		readyFile = fset.String("ready-file", "", "Writes the URL, PID and route count of the proxy as JSON to this file once it serves the routes, removing it on shutdown.")
+		tlsCert = fset.String("tls-cert", "", "Serves HTTPS using this PEM encoded certificate (requires --tls-key).")
+		tlsKey = fset.String("tls-key", "", "PEM encoded private key of the certificate given with --tls-cert.")
+		tlsSelfSigned = fset.Bool("tls-self-signed", false, "Serves HTTPS using a certificate issued by a local CA generated on first use (cannot be used with --tls-cert or --tls-key).")
+		tlsHostnames = fset.String("tls-hostnames", "", "Comma separated hostnames and IPs of the --tls-self-signed certificate (default localhost,127.0.0.1,::1).")
+		tlsDir = fset.String("tls-dir", "", "Directory where the --tls-self-signed CA and certificate persist (default <user config dir>/agbridge/tls).")
+		tlsClientCA = fset.String("tls-client-ca", "", "Requires callers to present a client certificate signed by a CA in this PEM bundle (mTLS).")
+		otlpEndpoint = fset.String("otlp-endpoint", "", "OTLP/HTTP collector URL where traces are exported, e.g. http://localhost:4318. Tracing is disabled when empty.")
+	}
 
-  # Set the listen address for the proxy server
-  %[1]s --listen-address=:9090
+	// The flags shared by the commands can also be set in config files and
+	// environment variables
+	var settings []string
+	fset.VisitAll(func(f *flag.Flag) {
+		if f.Name != "version" {
+			settings = append(settings, f.Name)
+		}
+	})
 
-  # Use the default config file (agbridge.yaml or agbridge.yml if they exist)
-  %[1]s
-`, args[0])
+	var apply func(flags *Flags) error
+	if c.register != nil {
+		apply = c.register(fset)
 	}
 
 	if err := fset.Parse(args); err != nil {
 		return nil, err
 	}
 
-	if *version {
+	if lo.FromPtr(version) {
 		return &Flags{Version: true}, nil
 	}
 
+	sources := make(map[string]string)
+	fset.Visit(func(f *flag.Flag) { sources[f.Name] = sourceFlag })
+	if err := applyEnv(fset, settings, sources); err != nil {
+		return &Flags{}, err
+	}
+	project := lo.FromPtr(config)
+	if project == "" {
+		project = defaultConfigFile(fs)
+	}
+	if err := applyConfigFiles(fs, fset, settings, configFiles(fs, project), sources); err != nil {
+		return &Flags{}, err
+	}
+
 	logLevel, err := log.ParseLogLevel(*logLevelStr)
 	if err != nil {
 		return &Flags{LogLevel: logLevel}, err
 	}
 
-	flags := &Flags{
-		Version:       *version,
-		Config:        *config,
-		ProfileName:   *profileName,
-		RestAPIID:     *restAPIID,
-		ListenAddress: *listenAddress,
-		LogLevel:      logLevel,
-		Region:        *region,
-		StageName:     *stageName,
+	logFormat, err := log.ParseFormat(*logFormatStr)
+	if err != nil {
+		return &Flags{LogLevel: logLevel, LogFormat: logFormat}, err
 	}
 
-	// Validate listen address format
-	if _, _, err := net.SplitHostPort(*listenAddress); err != nil {
-		return flags, fmt.Errorf("invalid listen address format: %w", err)
+	var accessLogFormat log.AccessFormat
+	if accessLogFormatStr != nil {
+		accessLogFormat, err = log.ParseAccessFormat(*accessLogFormatStr)
+		if err != nil {
+			return &Flags{LogLevel: logLevel, LogFormat: logFormat}, err
+		}
 	}
 
-	// Check if a custom config file is specified and verify its existence
-	if *config != "" {
-		if *profileName != "" || *restAPIID != "" || *region != "" || *stageName != "" {
-			return flags, errors.New("`--config` cannot be combined with `--profile-name`, `--rest-api-id`, `--region`, or `--stage-name`")
-		}
+	flags := &Flags{
+		AccessLog:       lo.FromPtr(accessLog),
+		AccessLogFormat: accessLogFormat,
+		Config:          lo.FromPtr(config),
+		Gateways:        gateways.gateways,
+		ProfileName:     lo.FromPtr(profileName),
+		ReadyFile:       lo.FromPtr(readyFile),
+		RestAPIID:       lo.FromPtr(restAPIID),
+		RouteCache:      lo.FromPtr(routeCache),
+		ListenAddresses: listenAddresses.values,
+		LoadWait:        lo.FromPtr(loadWait),
+		LogFile:         *logFile,
+		LogFormat:       logFormat,
+		LogLevel:        logLevel,
+		LogMaxSize:      *logMaxSize,
+		Offline:         lo.FromPtr(offline),
+		OTLPEndpoint:    lo.FromPtr(otlpEndpoint),
+		Region:          lo.FromPtr(region),
+		StageName:       lo.FromPtr(stageName),
+		TLSCert:         lo.FromPtr(tlsCert),
+		TLSClientCA:     lo.FromPtr(tlsClientCA),
+		TLSDir:          lo.FromPtr(tlsDir),
+		TLSKey:          lo.FromPtr(tlsKey),
+		TLSSelfSigned:   lo.FromPtr(tlsSelfSigned),
+	}
 
-		if _, err := fs.Stat(*config); os.IsNotExist(err) {
-			return flags, fmt.Errorf("config file does not exist: %w", err)
+	if apply != nil {
+		if err := apply(flags); err != nil {
+			return flags, err
 		}
-	} else {
-		// If no --config, check the rules for --rest-api-id, --region, and --profile-name
+	}
+
+	if fset.NArg() > 0 {
+		flags.Args = fset.Args()
+	}
+	if err := c.validateArgs(flags.Args); err != nil {
+		return flags, err
+	}
 
-		// --profile-name requires both --region and --rest-api-id
-		if *profileName != "" && (*restAPIID == "" || *region == "") {
-			return flags, errors.New("`--profile-name` requires both `--region` and `--rest-api-id` to be specified")
+	if c.flags&serverFlags != 0 {
+		if err := validateServerFlags(fs, flags, *tlsHostnames); err != nil {
+			return flags, err
 		}
+	}
 
-		// --region requires --rest-api-id
-		if *region != "" && *restAPIID == "" {
-			return flags, errors.New("`--region` requires `--rest-api-id` to be specified")
+	if c.flags&gatewayFlags != 0 {
+		if err := validateGatewayFlags(fs, flags); err != nil {
+			return flags, err
 		}
+	}
 
-		// If neither --config nor --rest-api-id is provided, fallback to default config file check
-		if *restAPIID == "" {
-			configFile, ok := lo.Find([]string{DefaultConfigFileYml, DefaultConfigFileYaml}, func(name string) bool {
-				_, err := fs.Stat(name)
-				return err == nil
-			})
-			if !ok {
-				return flags, errors.New("please provide `--rest-api-id`, `--config`, or ensure agbridge.yaml or agbridge.yml exists")
+	if c == configPrintCommand {
+		for _, name := range settings {
+			value := fset.Lookup(name).Value.String()
+			if name == "config" {
+				// The default project config file is found while validating
+				value = flags.Config
 			}
-			flags.Config = configFile
+			flags.Settings = append(flags.Settings, Setting{Name: name, Value: value, Source: lo.ValueOr(sources, name, sourceDefault)})
 		}
 	}
 
 	return flags, nil
 }
+
+func validateServerFlags(fs afero.Fs, flags *Flags, tlsHostnames string) error {
+	if tlsHostnames != "" {
+		flags.TLSHostnames = lo.Compact(lo.Map(strings.Split(tlsHostnames, ","), func(hostname string, _ int) string {
+			return strings.TrimSpace(hostname)
+		}))
+		if len(flags.TLSHostnames) == 0 {
+			return errors.New("`--tls-hostnames` requires at least one hostname")
+		}
+	}
+
+	// Validate listen address format
+	for _, listenAddress := range flags.ListenAddresses {
+		if err := proxy.ValidateListenAddress(listenAddress); err != nil {
+			return fmt.Errorf("invalid listen address format: %w", err)
+		}
+	}
+
+	// Both streams rotating the same file would overwrite each other
+	if flags.AccessLog != "" && flags.AccessLog != "-" && flags.LogFile != "" && samePath(flags.AccessLog, flags.LogFile) {
+		return errors.New("`--access-log` and `--log-file` must be different files")
+	}
+
+	return validateTLSFlags(fs, flags)
+}
+
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
+
+func validateGatewayFlags(fs afero.Fs, flags *Flags) error {
+	if flags.Offline && flags.RouteCache == "" {
+		return errors.New("`--offline` requires `--route-cache`")
+	}
+
+	// Check if a custom config file is specified and verify its existence
+	if flags.Config != "" {
+		if _, err := fs.Stat(flags.Config); os.IsNotExist(err) {
+			return fmt.Errorf("config file does not exist: %w", err)
+		}
+		return nil
+	}
+
+	// The project config file is merged even when the gateway is given with
+	// --rest-api-id
+	flags.Config = defaultConfigFile(fs)
+
+	if flags.RestAPIID == "" && flags.Gateways == nil && len(configFiles(fs, flags.Config)) == 0 {
+		return errors.New("please provide `--rest-api-id`, `--gateway`, `--config`, or ensure agbridge.yaml or agbridge.yml exists")
+	}
+
+	return nil
+}
+
+func validateTLSFlags(fs afero.Fs, flags *Flags) error {
+	if (flags.TLSCert == "") != (flags.TLSKey == "") {
+		return errors.New("`--tls-cert` and `--tls-key` must be specified together")
+	}
+
+	if flags.TLSSelfSigned && flags.TLSCert != "" {
+		return errors.New("`--tls-self-signed` cannot be combined with `--tls-cert` or `--tls-key`")
+	}
+
+	if !flags.TLSSelfSigned && (flags.TLSHostnames != nil || flags.TLSDir != "") {
+		return errors.New("`--tls-hostnames` and `--tls-dir` require `--tls-self-signed`")
+	}
+
+	if flags.TLSClientCA != "" && !flags.TLSSelfSigned && flags.TLSCert == "" {
+		return errors.New("`--tls-client-ca` requires `--tls-cert` and `--tls-key`, or `--tls-self-signed`")
+	}
+
+	for _, file := range []string{flags.TLSCert, flags.TLSKey, flags.TLSClientCA} {
+		if file == "" {
+			continue
+		}
+		if _, err := fs.Stat(file); os.IsNotExist(err) {
+			return fmt.Errorf("TLS file does not exist: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// listFlag is a flag repeated or separated by commas, the values given
+// replace the default.
+type listFlag struct {
+	values []string
+	set    bool
+}
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(l.values, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	if !l.set {
+		l.values, l.set = nil, true
+	}
+	l.values = append(l.values, strings.Split(value, ",")...)
+	return nil
+}
+
+// gatewaysFlag is a repeated flag adding a gateway, as comma separated
+// key=value pairs.
+type gatewaysFlag struct {
+	gateways []proxy.GatewayConfig
+	specs    []string
+}
+
+func (g *gatewaysFlag) String() string {
+	if g == nil {
+		return ""
+	}
+	return strings.Join(g.specs, " ")
+}
+
+func (g *gatewaysFlag) Set(value string) error {
+	gw, err := parseGateway(value)
+	if err != nil {
+		return err
+	}
+	g.gateways = append(g.gateways, gw)
+	g.specs = append(g.specs, value)
+	return nil
+}
+
+// parseGateway parses a gateway like
+// id=abc123,profile=dev,region=eu-west-1,stage=prod,prefix=/orders.
+func parseGateway(spec string) (proxy.GatewayConfig, error) {
+	var gw proxy.GatewayConfig
+	fields := map[string]*string{
+		"id":      &gw.RestAPIID,
+		"profile": &gw.ProfileName,
+		"region":  &gw.Region,
+		"stage":   &gw.StageName,
+		"prefix":  &gw.PathPrefix,
+		"listen":  &gw.ListenAddress,
+	}
+
+	seen := make(map[string]bool)
+	for _, pair := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || value == "" {
+			return gw, fmt.Errorf("invalid gateway %q: %q must be key=value", spec, pair)
+		}
+		field, ok := fields[key]
+		if !ok {
+			return gw, fmt.Errorf("invalid gateway %q: unknown key %q, must be one of id, profile, region, stage, prefix, listen", spec, key)
+		}
+		if seen[key] {
+			return gw, fmt.Errorf("invalid gateway %q: %s given more than once", spec, key)
+		}
+		seen[key] = true
+		*field = strings.TrimSpace(value)
+	}
+
+	if gw.RestAPIID == "" {
+		return gw, fmt.Errorf("invalid gateway %q: id is required", spec)
+	}
+	return gw, nil
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/oscarbc96/agbridge/pkg/proxy"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -32,12 +33,16 @@ func TestParseFlags(t *testing.T) {
 			args:   []string{},
 			expErr: "",
 			expOpts: &Flags{
-				Config:        "agbridge.yaml",
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				Config:          "agbridge.yaml",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
 			},
 			setup: func(t *testing.T, fs afero.Fs) {
-				require.NoError(t, afero.WriteFile(fs, DefaultConfigFileYaml, []byte("dummy"), 0o644))
+				require.NoError(t, afero.WriteFile(fs, DefaultConfigFileYaml, []byte("gateways: []"), 0o644))
 			},
 		},
 		{
@@ -45,11 +50,15 @@ func TestParseFlags(t *testing.T) {
 			args:   []string{"--rest-api-id", "12345"},
 			expErr: "",
 			expOpts: &Flags{
-				RestAPIID:     "12345",
-				ProfileName:   "",
-				Region:        "",
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				RestAPIID:       "12345",
+				ProfileName:     "",
+				Region:          "",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
 			},
 		},
 		{
@@ -57,11 +66,15 @@ func TestParseFlags(t *testing.T) {
 			args:   []string{"--region", "eu-west-1", "--rest-api-id", "12345"},
 			expErr: "",
 			expOpts: &Flags{
-				RestAPIID:     "12345",
-				ProfileName:   "",
-				Region:        "eu-west-1",
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				RestAPIID:       "12345",
+				ProfileName:     "",
+				Region:          "eu-west-1",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
 			},
 		},
 		{
@@ -69,75 +82,111 @@ func TestParseFlags(t *testing.T) {
 			args:   []string{"--region", "eu-west-1", "--rest-api-id", "12345", "--profile-name", "patata"},
 			expErr: "",
 			expOpts: &Flags{
-				RestAPIID:     "12345",
-				ProfileName:   "patata",
-				Region:        "eu-west-1",
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				RestAPIID:       "12345",
+				ProfileName:     "patata",
+				Region:          "eu-west-1",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
 			},
 		},
 		{
 			name:   "ProfileName without Region and RestAPIID",
 			args:   []string{"--profile-name", "patata"},
-			expErr: "`--profile-name` requires both `--region` and `--rest-api-id` to be specified",
+			expErr: "please provide `--rest-api-id`, `--gateway`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
 			expOpts: &Flags{
-				ProfileName:   "patata",
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				ProfileName:     "patata",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
 			},
 		},
 		{
 			name:   "Region without RestAPIID",
 			args:   []string{"--region", "eu-west-1"},
-			expErr: "`--region` requires `--rest-api-id` to be specified",
+			expErr: "please provide `--rest-api-id`, `--gateway`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
 			expOpts: &Flags{
-				Region:        "eu-west-1",
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				Region:          "eu-west-1",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
 			},
 		},
 		{
-			name:   "Config and RestAPIID",
-			args:   []string{"--config", "config.yaml", "--rest-api-id", "12345"},
-			expErr: "`--config` cannot be combined with `--profile-name`, `--rest-api-id`, `--region`, or `--stage-name`",
+			name: "Config and RestAPIID",
+			args: []string{"--config", "config.yaml", "--rest-api-id", "12345"},
 			expOpts: &Flags{
-				Config:        "config.yaml",
-				RestAPIID:     "12345",
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				Config:          "config.yaml",
+				RestAPIID:       "12345",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
+			},
+			setup: func(t *testing.T, fs afero.Fs) {
+				require.NoError(t, afero.WriteFile(fs, "config.yaml", []byte("gateways: []"), 0o644))
 			},
 		},
 		{
-			name:   "Config and ProfileName",
-			args:   []string{"--config", "config.yaml", "--profile-name", "testprofile"},
-			expErr: "`--config` cannot be combined with `--profile-name`, `--rest-api-id`, `--region`, or `--stage-name`",
+			name: "Config and ProfileName",
+			args: []string{"--config", "config.yaml", "--profile-name", "testprofile"},
 			expOpts: &Flags{
-				Config:        "config.yaml",
-				ProfileName:   "testprofile",
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				Config:          "config.yaml",
+				ProfileName:     "testprofile",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
+			},
+			setup: func(t *testing.T, fs afero.Fs) {
+				require.NoError(t, afero.WriteFile(fs, "config.yaml", []byte("gateways: []"), 0o644))
 			},
 		},
 		{
-			name:   "Config and Region",
-			args:   []string{"--config", "config.yaml", "--region", "eu-west-1"},
-			expErr: "`--config` cannot be combined with `--profile-name`, `--rest-api-id`, `--region`, or `--stage-name`",
+			name: "Config and Region",
+			args: []string{"--config", "config.yaml", "--region", "eu-west-1"},
 			expOpts: &Flags{
-				Config:        "config.yaml",
-				Region:        "eu-west-1",
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				Config:          "config.yaml",
+				Region:          "eu-west-1",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
+			},
+			setup: func(t *testing.T, fs afero.Fs) {
+				require.NoError(t, afero.WriteFile(fs, "config.yaml", []byte("gateways: []"), 0o644))
 			},
 		},
 		{
-			name:   "Config and StageName",
-			args:   []string{"--config", "config.yaml", "--stage-name", "test"},
-			expErr: "`--config` cannot be combined with `--profile-name`, `--rest-api-id`, `--region`, or `--stage-name`",
+			name: "Config and StageName",
+			args: []string{"--config", "config.yaml", "--stage-name", "test"},
 			expOpts: &Flags{
-				Config:        "config.yaml",
-				StageName:     "test",
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				Config:          "config.yaml",
+				StageName:       "test",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
+			},
+			setup: func(t *testing.T, fs afero.Fs) {
+				require.NoError(t, afero.WriteFile(fs, "config.yaml", []byte("gateways: []"), 0o644))
 			},
 		},
 		{
@@ -145,18 +194,26 @@ func TestParseFlags(t *testing.T) {
 			args:   []string{"--config", "nonexistent.yaml"},
 			expErr: "config file does not exist: open nonexistent.yaml: file does not exist",
 			expOpts: &Flags{
-				Config:        "nonexistent.yaml",
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				Config:          "nonexistent.yaml",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
 			},
 		},
 		{
 			name:   "No Default Config Files",
 			args:   []string{},
-			expErr: "please provide `--rest-api-id`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
+			expErr: "please provide `--rest-api-id`, `--gateway`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
 			expOpts: &Flags{
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
 			},
 		},
 		{
@@ -164,12 +221,16 @@ func TestParseFlags(t *testing.T) {
 			args:   []string{},
 			expErr: "",
 			expOpts: &Flags{
-				Config:        "agbridge.yml",
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				Config:          "agbridge.yml",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
 			},
 			setup: func(t *testing.T, fs afero.Fs) {
-				require.NoError(t, afero.WriteFile(fs, DefaultConfigFileYml, []byte("dummy"), 0o644))
+				require.NoError(t, afero.WriteFile(fs, DefaultConfigFileYml, []byte("gateways: []"), 0o644))
 			},
 		},
 		{
@@ -177,21 +238,29 @@ func TestParseFlags(t *testing.T) {
 			args:   []string{},
 			expErr: "",
 			expOpts: &Flags{
-				Config:        "agbridge.yaml",
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				Config:          "agbridge.yaml",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
 			},
 			setup: func(t *testing.T, fs afero.Fs) {
-				require.NoError(t, afero.WriteFile(fs, DefaultConfigFileYaml, []byte("dummy"), 0o644))
+				require.NoError(t, afero.WriteFile(fs, DefaultConfigFileYaml, []byte("gateways: []"), 0o644))
 			},
 		},
 		{
 			name:   "Valid Listen Address",
 			args:   []string{"--listen-address", ":9090"},
-			expErr: "please provide `--rest-api-id`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
+			expErr: "please provide `--rest-api-id`, `--gateway`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
 			expOpts: &Flags{
-				ListenAddress: ":9090",
-				LogLevel:      log.LevelInfo,
+				ListenAddresses: []string{":9090"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
 			},
 		},
 		{
@@ -199,53 +268,377 @@ func TestParseFlags(t *testing.T) {
 			args:   []string{"--listen-address", "qwerty"},
 			expErr: "invalid listen address format: address qwerty: missing port in address",
 			expOpts: &Flags{
-				ListenAddress: "qwerty",
-				LogLevel:      log.LevelInfo,
+				ListenAddresses: []string{"qwerty"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
+			},
+		},
+		{
+			name:   "Multiple Listen Addresses",
+			args:   []string{"--rest-api-id", "12345", "--listen-address", ":9090,unix:///tmp/agbridge.sock", "--listen-address", "127.0.0.1:9091"},
+			expErr: "",
+			expOpts: &Flags{
+				RestAPIID:       "12345",
+				ListenAddresses: []string{":9090", "unix:///tmp/agbridge.sock", "127.0.0.1:9091"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
+			},
+		},
+		{
+			name:   "Invalid Unix Socket Listen Address",
+			args:   []string{"--listen-address", "unix://"},
+			expErr: "invalid listen address format: address unix://: missing socket path",
+			expOpts: &Flags{
+				ListenAddresses: []string{"unix://"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
 			},
 		},
 		{
 			name:   "Valid LogLevel - Debug",
 			args:   []string{"--log-level", "debug"},
-			expErr: "please provide `--rest-api-id`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
+			expErr: "please provide `--rest-api-id`, `--gateway`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
 			expOpts: &Flags{
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelDebug,
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelDebug,
 			},
 		},
 		{
 			name:   "Valid LogLevel - Info",
 			args:   []string{"--log-level", "info"},
-			expErr: "please provide `--rest-api-id`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
+			expErr: "please provide `--rest-api-id`, `--gateway`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
 			expOpts: &Flags{
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelInfo,
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
 			},
 		},
 		{
 			name:   "Valid LogLevel - Warn",
 			args:   []string{"--log-level", "warn"},
-			expErr: "please provide `--rest-api-id`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
+			expErr: "please provide `--rest-api-id`, `--gateway`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
 			expOpts: &Flags{
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelWarn,
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelWarn,
 			},
 		},
 		{
 			name:   "Valid LogLevel - Error",
 			args:   []string{"--log-level", "error"},
-			expErr: "please provide `--rest-api-id`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
+			expErr: "please provide `--rest-api-id`, `--gateway`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
 			expOpts: &Flags{
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelError,
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelError,
 			},
 		},
 		{
 			name:   "Valid LogLevel - Fatal",
 			args:   []string{"--log-level", "fatal"},
-			expErr: "please provide `--rest-api-id`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
+			expErr: "please provide `--rest-api-id`, `--gateway`, `--config`, or ensure agbridge.yaml or agbridge.yml exists",
+			expOpts: &Flags{
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelFatal,
+			},
+		},
+		{
+			name:   "Invalid LogFormat",
+			args:   []string{"--log-format", "xml"},
+			expErr: "invalid log format: must be one of console, json, logfmt",
+			expOpts: &Flags{
+				LogLevel:  log.LevelInfo,
+				LogFormat: log.FormatConsole,
+			},
+		},
+		{
+			name:   "Invalid AccessLogFormat",
+			args:   []string{"--access-log-format", "combined"},
+			expErr: "invalid access log format: must be one of clf, json",
+			expOpts: &Flags{
+				LogLevel:  log.LevelInfo,
+				LogFormat: log.FormatConsole,
+			},
+		},
+		{
+			name:   "Valid LogFormat and AccessLog",
+			args:   []string{"--rest-api-id", "12345", "--log-format", "json", "--log-file", "agbridge.log", "--access-log", "-", "--access-log-format", "json"},
+			expErr: "",
+			expOpts: &Flags{
+				RestAPIID:       "12345",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogLevel:        log.LevelInfo,
+				LogFormat:       log.FormatJSON,
+				LogFile:         "agbridge.log",
+				LogMaxSize:      100,
+				AccessLog:       "-",
+				AccessLogFormat: log.AccessFormatJSON,
+			},
+		},
+		{
+			name:   "TLS cert without key",
+			args:   []string{"--rest-api-id", "12345", "--tls-cert", "cert.pem"},
+			expErr: "`--tls-cert` and `--tls-key` must be specified together",
+			expOpts: &Flags{
+				RestAPIID:       "12345",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogLevel:        log.LevelInfo,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				TLSCert:         "cert.pem",
+			},
+		},
+		{
+			name:   "TLS self-signed and cert",
+			args:   []string{"--rest-api-id", "12345", "--tls-self-signed", "--tls-cert", "cert.pem", "--tls-key", "key.pem"},
+			expErr: "`--tls-self-signed` cannot be combined with `--tls-cert` or `--tls-key`",
+			expOpts: &Flags{
+				RestAPIID:       "12345",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogLevel:        log.LevelInfo,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				TLSSelfSigned:   true,
+				TLSCert:         "cert.pem",
+				TLSKey:          "key.pem",
+			},
+		},
+		{
+			name:   "Offline with route cache",
+			args:   []string{"--rest-api-id", "12345", "--route-cache", "routes", "--offline"},
+			expErr: "",
+			expOpts: &Flags{
+				RestAPIID:       "12345",
+				RouteCache:      "routes",
+				Offline:         true,
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogLevel:        log.LevelInfo,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+			},
+		},
+		{
+			name:   "Offline without route cache",
+			args:   []string{"--rest-api-id", "12345", "--offline"},
+			expErr: "`--offline` requires `--route-cache`",
+			expOpts: &Flags{
+				RestAPIID:       "12345",
+				Offline:         true,
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogLevel:        log.LevelInfo,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+			},
+		},
+		{
+			name:   "Access log in the log file",
+			args:   []string{"--rest-api-id", "12345", "--log-file", "logs/agbridge.log", "--access-log", "./logs/agbridge.log"},
+			expErr: "`--access-log` and `--log-file` must be different files",
+			expOpts: &Flags{
+				RestAPIID:       "12345",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogLevel:        log.LevelInfo,
+				LogFormat:       log.FormatConsole,
+				LogFile:         "logs/agbridge.log",
+				LogMaxSize:      100,
+				AccessLog:       "./logs/agbridge.log",
+				AccessLogFormat: log.AccessFormatCommon,
+			},
+		},
+		{
+			name:   "TLS hostnames without self-signed",
+			args:   []string{"--rest-api-id", "12345", "--tls-hostnames", "localhost"},
+			expErr: "`--tls-hostnames` and `--tls-dir` require `--tls-self-signed`",
+			expOpts: &Flags{
+				RestAPIID:       "12345",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogLevel:        log.LevelInfo,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				TLSHostnames:    []string{"localhost"},
+			},
+		},
+		{
+			name:   "TLS client CA without TLS",
+			args:   []string{"--rest-api-id", "12345", "--tls-client-ca", "ca.pem"},
+			expErr: "`--tls-client-ca` requires `--tls-cert` and `--tls-key`, or `--tls-self-signed`",
+			expOpts: &Flags{
+				RestAPIID:       "12345",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogLevel:        log.LevelInfo,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				TLSClientCA:     "ca.pem",
+			},
+		},
+		{
+			name:   "TLS cert does not exist",
+			args:   []string{"--rest-api-id", "12345", "--tls-cert", "cert.pem", "--tls-key", "key.pem"},
+			expErr: "TLS file does not exist: open cert.pem: file does not exist",
 			expOpts: &Flags{
-				ListenAddress: ":8080",
-				LogLevel:      log.LevelFatal,
+				RestAPIID:       "12345",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogLevel:        log.LevelInfo,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				TLSCert:         "cert.pem",
+				TLSKey:          "key.pem",
+			},
+		},
+		{
+			name:   "TLS hostnames with empty entries",
+			args:   []string{"--rest-api-id", "12345", "--tls-self-signed", "--tls-hostnames", ",localhost, ,"},
+			expErr: "",
+			expOpts: &Flags{
+				RestAPIID:       "12345",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogLevel:        log.LevelInfo,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				TLSSelfSigned:   true,
+				TLSHostnames:    []string{"localhost"},
+			},
+		},
+		{
+			name:   "TLS hostnames without hostnames",
+			args:   []string{"--rest-api-id", "12345", "--tls-self-signed", "--tls-hostnames", ","},
+			expErr: "`--tls-hostnames` requires at least one hostname",
+			expOpts: &Flags{
+				RestAPIID:       "12345",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogLevel:        log.LevelInfo,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				TLSSelfSigned:   true,
+				TLSHostnames:    []string{},
+			},
+		},
+		{
+			name:   "Valid TLS self-signed with client CA",
+			args:   []string{"--rest-api-id", "12345", "--tls-self-signed", "--tls-hostnames", "localhost,agbridge.local", "--tls-dir", "tls", "--tls-client-ca", "ca.pem"},
+			expErr: "",
+			expOpts: &Flags{
+				RestAPIID:       "12345",
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogLevel:        log.LevelInfo,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				TLSSelfSigned:   true,
+				TLSHostnames:    []string{"localhost", "agbridge.local"},
+				TLSDir:          "tls",
+				TLSClientCA:     "ca.pem",
+			},
+			setup: func(t *testing.T, fs afero.Fs) {
+				require.NoError(t, afero.WriteFile(fs, "ca.pem", []byte("dummy"), 0o644))
+			},
+		},
+		{
+			name: "Settings from config file",
+			args: []string{},
+			expOpts: &Flags{
+				Config:          DefaultConfigFileYaml,
+				ListenAddresses: []string{":9090", "unix:///tmp/agbridge.sock"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatJSON,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelDebug,
+			},
+			setup: func(t *testing.T, fs afero.Fs) {
+				config := "log_level: debug\nlog_format: json\nlisten_address:\n  - :9090\n  - unix:///tmp/agbridge.sock\ngateways: []\n"
+				require.NoError(t, afero.WriteFile(fs, DefaultConfigFileYaml, []byte(config), 0o644))
+			},
+		},
+		{
+			name: "Flags override config file settings",
+			args: []string{"--log-level", "warn", "--rest-api-id", "12345", "--stage-name", "dev"},
+			expOpts: &Flags{
+				Config:          DefaultConfigFileYaml,
+				RestAPIID:       "12345",
+				StageName:       "dev",
+				ListenAddresses: []string{":9090"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelWarn,
+			},
+			setup: func(t *testing.T, fs afero.Fs) {
+				config := "log_level: debug\nlisten_address: :9090\nrest_api_id: ignored\ngateways: []\n"
+				require.NoError(t, afero.WriteFile(fs, DefaultConfigFileYaml, []byte(config), 0o644))
+			},
+		},
+		{
+			name:    "Invalid setting in config file",
+			args:    []string{},
+			expErr:  "invalid log_max_size in agbridge.yaml: parse error",
+			expOpts: &Flags{},
+			setup: func(t *testing.T, fs afero.Fs) {
+				require.NoError(t, afero.WriteFile(fs, DefaultConfigFileYaml, []byte("log_max_size: big\n"), 0o644))
+			},
+		},
+		{
+			name: "Repeated gateways",
+			args: []string{"--gateway", "id=abc123,profile=dev,region=eu-west-1,stage=prod,prefix=/orders", "--gateway", "id=def456,stage=dev"},
+			expOpts: &Flags{
+				Gateways: []proxy.GatewayConfig{
+					{RestAPIID: "abc123", ProfileName: "dev", Region: "eu-west-1", StageName: "prod", PathPrefix: "/orders"},
+					{RestAPIID: "def456", StageName: "dev"},
+				},
+				ListenAddresses: []string{":8080"},
+				LoadWait:        DefaultLoadWait,
+				LogFormat:       log.FormatConsole,
+				LogMaxSize:      100,
+				AccessLogFormat: log.AccessFormatCommon,
+				LogLevel:        log.LevelInfo,
 			},
 		},
 		{
@@ -279,3 +672,59 @@ func TestParseFlags(t *testing.T) {
 		})
 	}
 }
+
+func TestParseGateway(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		spec   string
+		exp    proxy.GatewayConfig
+		expErr string
+	}{
+		{
+			name: "Every key",
+			spec: "id=abc123,profile=dev,region=eu-west-1,stage=prod,prefix=/orders,listen=:9090",
+			exp:  proxy.GatewayConfig{RestAPIID: "abc123", ProfileName: "dev", Region: "eu-west-1", StageName: "prod", PathPrefix: "/orders", ListenAddress: ":9090"},
+		},
+		{
+			name: "Only id",
+			spec: "id=abc123",
+			exp:  proxy.GatewayConfig{RestAPIID: "abc123"},
+		},
+		{
+			name:   "Missing id",
+			spec:   "stage=prod",
+			expErr: `invalid gateway "stage=prod": id is required`,
+		},
+		{
+			name:   "Unknown key",
+			spec:   "id=abc123,stage_name=prod",
+			expErr: `invalid gateway "id=abc123,stage_name=prod": unknown key "stage_name", must be one of id, profile, region, stage, prefix, listen`,
+		},
+		{
+			name:   "Without value",
+			spec:   "id=abc123,stage",
+			expErr: `invalid gateway "id=abc123,stage": "stage" must be key=value`,
+		},
+		{
+			name:   "Repeated key",
+			spec:   "id=abc123,id=def456",
+			expErr: `invalid gateway "id=abc123,id=def456": id given more than once`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			gw, err := parseGateway(tt.spec)
+			if tt.expErr != "" {
+				require.EqualError(t, err, tt.expErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.exp, gw)
+		})
+	}
+}
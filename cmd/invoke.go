@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/spf13/afero"
+)
+
+// readData returns the body given with -d, reading it from a file or stdin
+// when it starts with @.
+func readData(fs afero.Fs, data string, stdin io.Reader) ([]byte, error) {
+	filename, ok := strings.CutPrefix(data, "@")
+	if !ok {
+		return []byte(data), nil
+	}
+	if filename == "-" {
+		return io.ReadAll(stdin)
+	}
+	return afero.ReadFile(fs, filename)
+}
+
+func newInvokeRequest(fs afero.Fs, flags *Flags, stdin io.Reader) (*http.Request, error) {
+	body, err := readData(fs, flags.Data, stdin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	target, err := url.Parse(flags.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid path: %w", err)
+	}
+
+	r, err := http.NewRequest(flags.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+	// Like a request received by the proxy
+	r.RequestURI = target.RequestURI()
+	for _, header := range flags.Headers {
+		name, value, _ := strings.Cut(header, ":")
+		r.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	return r, nil
+}
+
+// printResponse writes the status line, headers and body of the response.
+func printResponse(w io.Writer, status int, headers http.Header, body string) {
+	fmt.Fprintf(w, "%d %s\n", status, http.StatusText(status))
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range headers[name] {
+			fmt.Fprintf(w, "%s: %s\n", name, value)
+		}
+	}
+
+	fmt.Fprintf(w, "\n%s\n", body)
+}
+
+func runInvoke(fs afero.Fs, flags *Flags) {
+	server := newProxy(fs, flags, nil)
+
+	if err := server.Reload(); err != nil {
+		log.Fatal("Configuration validation failed", log.Err(err))
+	}
+
+	r, err := newInvokeRequest(fs, flags, os.Stdin)
+	if err != nil {
+		log.Fatal("Invalid request", log.Err(err))
+	}
+
+	result := server.Invoke(r)
+
+	if !flags.ShowLog {
+		result.Log = ""
+	} else if flags.Output != "json" && result.Log != "" {
+		fmt.Fprintln(os.Stderr, result.Log)
+	}
+
+	if flags.Output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			log.Fatal("Failed to encode response", log.Err(err))
+		}
+	} else {
+		printResponse(os.Stdout, result.Status, result.Headers, result.Body)
+	}
+
+	// Fail like curl --fail, for scripts
+	if result.Status >= http.StatusBadRequest {
+		os.Exit(1)
+	}
+}
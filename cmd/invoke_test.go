@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewInvokeRequest(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "body.json", []byte(`{"from": "file"}`), 0o644))
+
+	tests := []struct {
+		name    string
+		data    string
+		stdin   string
+		expBody string
+		expErr  string
+	}{
+		{name: "Inline", data: `{"from": "flag"}`, expBody: `{"from": "flag"}`},
+		{name: "File", data: "@body.json", expBody: `{"from": "file"}`},
+		{name: "Stdin", data: "@-", stdin: `{"from": "stdin"}`, expBody: `{"from": "stdin"}`},
+		{name: "Missing file", data: "@missing.json", expErr: "failed to read request body: open missing.json: file does not exist"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			flags := &Flags{
+				Method:  http.MethodPost,
+				Args:    []string{"/dev/orders?dry_run=true"},
+				Headers: []string{"Content-Type: application/json", "X-Tag: a", "X-Tag: b"},
+				Data:    tt.data,
+			}
+
+			r, err := newInvokeRequest(fs, flags, strings.NewReader(tt.stdin))
+			if tt.expErr != "" {
+				require.EqualError(t, err, tt.expErr)
+				return
+			}
+			require.NoError(t, err)
+
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expBody, string(body))
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "/dev/orders", r.URL.Path)
+			assert.Equal(t, "true", r.URL.Query().Get("dry_run"))
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			assert.Equal(t, []string{"a", "b"}, r.Header.Values("X-Tag"))
+		})
+	}
+}
+
+func TestNewInvokeRequestPath(t *testing.T) {
+	t.Parallel()
+
+	r, err := newInvokeRequest(afero.NewMemMapFs(), &Flags{Method: http.MethodGet, Args: []string{"/users/a b?q=1"}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/users/a b", r.URL.Path)
+	assert.Equal(t, "/users/a%20b?q=1", r.RequestURI)
+
+	_, err = newInvokeRequest(afero.NewMemMapFs(), &Flags{Method: http.MethodGet, Args: []string{"/users/%zz"}}, nil)
+	require.ErrorContains(t, err, "invalid path")
+}
+
+func TestPrintResponse(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	printResponse(&out, http.StatusNotFound, http.Header{"X-Request-Id": {"abc"}, "Content-Type": {"application/json"}}, `{"message": "not found"}`)
+
+	assert.Equal(t, "404 Not Found\nContent-Type: application/json\nX-Request-Id: abc\n\n{\"message\": \"not found\"}\n", out.String())
+}
@@ -1,15 +1,10 @@
 package main
 
 import (
-	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
 
 	"github.com/oscarbc96/agbridge/pkg/log"
 	"github.com/oscarbc96/agbridge/pkg/proxy"
@@ -22,31 +17,33 @@ var (
 	date    = "unknown"
 )
 
+// loadProxyConfig merges the system, user and project config files, and
+// applies the gateway flags over them.
 func loadProxyConfig(fs afero.Fs, flags *Flags) (*proxy.Config, error) {
-	if flags.RestAPIID != "" {
-		log.Info("Loading configuration from provided flags")
-		return proxy.NewConfig(flags.RestAPIID, flags.ProfileName, flags.Region, flags.StageName), nil
-	}
-
-	log.Info("Loading configuration from config file", log.String("config", flags.Config))
-	cfg, err := proxy.LoadConfig(fs, flags.Config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load config file %s: %w", flags.Config, err)
-	}
-
-	return cfg, nil
+	cfg, _, err := loadLayeredConfig(fs, flags)
+	return cfg, err
 }
 
 func main() {
 	fs := afero.NewOsFs()
 
-	flags, err := parseFlags(fs, os.Args[1:])
+	cmd, args := findCommand(os.Args[1:])
+	if cmd == nil {
+		printCommands(os.Stderr)
+		if args[0] != "help" && !isHelpFlag(args[0]) {
+			fmt.Fprintf(os.Stderr, "\nUnknown command %q\n", args[0])
+			os.Exit(2)
+		}
+		return
+	}
+
+	flags, err := cmd.parseFlags(fs, args)
 	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
 
 	// Setup logging, before raising errors during flags parsing
-	log.Setup(flags.LogLevel)
+	log.Setup(flags.LogLevel, flags.LogFormat, log.Output(flags.LogFile, flags.LogMaxSize))
 	if err != nil {
 		log.Fatal(err.Error())
 	}
@@ -56,42 +53,5 @@ func main() {
 		return
 	}
 
-	cfg, err := loadProxyConfig(fs, flags)
-	if err != nil {
-		log.Fatal("Failed to load configuration", log.Err(err))
-	}
-
-	handlerMapping, err := cfg.Validate()
-	if err != nil {
-		log.Fatal("Configuration validation failed", log.Err(err))
-	}
-
-	err = proxy.PrintMappings(handlerMapping)
-	if err != nil {
-		log.Fatal("Failed to print mappings", log.Err(err))
-	}
-
-	proxy := proxy.NewProxy(flags.ListenAddress, handlerMapping)
-
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
-
-	go func() {
-		log.Info("Starting proxy server", log.String("address", proxy.Addr()))
-		if err := proxy.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatal("Proxy server encountered an error", log.Err(err))
-		}
-	}()
-
-	<-ctx.Done()
-	log.Info("Shutdown signal received, stopping proxy server...")
-
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	if err := proxy.Shutdown(shutdownCtx); err != nil {
-		log.Fatal("Failed to stop proxy server gracefully", log.Err(err))
-	} else {
-		log.Info("Proxy server stopped successfully")
-	}
+	cmd.run(fs, flags)
 }
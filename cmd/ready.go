@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oscarbc96/agbridge/pkg/proxy"
+	"github.com/spf13/afero"
+)
+
+// readyFile is written with --ready-file once the proxy listens and its
+// routes are loaded.
+type readyFile struct {
+	URL       string         `json:"url"`
+	Addresses []string       `json:"addresses"`
+	PID       int            `json:"pid"`
+	Routes    int            `json:"routes"`
+	Gateways  []readyGateway `json:"gateways"`
+}
+
+type readyGateway struct {
+	RestAPIID string `json:"rest_api_id"`
+	StageName string `json:"stage_name,omitempty"`
+	URL       string `json:"url,omitempty"`
+	Routes    int    `json:"routes"`
+}
+
+func newReadyFile(server *proxy.Proxy, pid int) readyFile {
+	gateways := server.Gateways()
+	urls := server.GatewayURLs()
+
+	ready := readyFile{
+		URL:       server.URL(),
+		Addresses: server.Addrs(),
+		PID:       pid,
+		Routes:    len(server.HandlerMapping()),
+		Gateways:  make([]readyGateway, len(gateways)),
+	}
+	for i, gw := range gateways {
+		ready.Gateways[i] = readyGateway{RestAPIID: gw.RestAPIID, StageName: gw.StageName, URL: urls[i], Routes: gw.Routes}
+	}
+	return ready
+}
+
+// checkReady returns an error naming the gateways not ready, the ready file is
+// only written once every gateway serves its routes, like /readyz reports.
+func checkReady(gateways []proxy.GatewayStatus) error {
+	var notReady []string
+	for _, gw := range gateways {
+		if gw.Ready {
+			continue
+		}
+		reason := gw.Error
+		if reason == "" {
+			reason = "still loading"
+		}
+		notReady = append(notReady, fmt.Sprintf("%s: %s", gw.RestAPIID, reason))
+	}
+	if len(notReady) > 0 {
+		return fmt.Errorf("gateways not ready: %s", strings.Join(notReady, "; "))
+	}
+	return nil
+}
+
+// writeReadyFile writes the ready file to a temporary file renamed to
+// filename, so readers never see it partially written.
+func writeReadyFile(fs afero.Fs, filename string, ready readyFile) error {
+	data, err := json.MarshalIndent(ready, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(filename), fmt.Sprintf(".%s.%d.tmp", filepath.Base(filename), os.Getpid()))
+	if err := afero.WriteFile(fs, tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write ready file: %w", err)
+	}
+	if err := fs.Rename(tmp, filename); err != nil {
+		_ = fs.Remove(tmp)
+		return fmt.Errorf("failed to write ready file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/oscarbc96/agbridge/pkg/proxy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteReadyFile(t *testing.T) {
+	t.Parallel()
+
+	server := proxy.NewProxy([]string{"127.0.0.1:0"}, proxy.NewConfig("abc123", "", "", "dev"))
+	require.NoError(t, server.Listen())
+	t.Cleanup(func() { _ = server.Shutdown(t.Context()) })
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, fs.MkdirAll("/tmp/agbridge", 0o755))
+	require.NoError(t, writeReadyFile(fs, "/tmp/agbridge/ready.json", newReadyFile(server, 42)))
+
+	data, err := afero.ReadFile(fs, "/tmp/agbridge/ready.json")
+	require.NoError(t, err)
+
+	var ready readyFile
+	require.NoError(t, json.Unmarshal(data, &ready))
+	assert.Equal(t, "http://"+server.Addrs()[0], ready.URL)
+	assert.NotContains(t, ready.URL, ":0")
+	assert.Equal(t, 42, ready.PID)
+	assert.Equal(t, []readyGateway{{RestAPIID: "abc123", StageName: "dev", URL: ready.URL + "/dev"}}, ready.Gateways)
+
+	files, err := afero.ReadDir(fs, "/tmp/agbridge")
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "expected the temporary file to be renamed")
+}
+
+func TestCheckReady(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		gateways []proxy.GatewayStatus
+		expErr   string
+	}{
+		{
+			name:     "Every gateway ready",
+			gateways: []proxy.GatewayStatus{{RestAPIID: "abc123", Ready: true, Routes: 3}},
+		},
+		{
+			name:     "No gateways",
+			gateways: nil,
+		},
+		{
+			name: "Failed gateway",
+			gateways: []proxy.GatewayStatus{
+				{RestAPIID: "abc123", Ready: true, Routes: 3},
+				{RestAPIID: "def456", Error: "access denied"},
+			},
+			expErr: "gateways not ready: def456: access denied",
+		},
+		{
+			name: "Every gateway failed or loading",
+			gateways: []proxy.GatewayStatus{
+				{RestAPIID: "abc123", Error: "access denied"},
+				{RestAPIID: "def456"},
+			},
+			expErr: "gateways not ready: abc123: access denied; def456: still loading",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := checkReady(tt.gateways)
+			if tt.expErr != "" {
+				require.EqualError(t, err, tt.expErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/oscarbc96/agbridge/pkg/proxy"
+	"github.com/spf13/afero"
+)
+
+func runRoutes(fs afero.Fs, flags *Flags) {
+	server := newProxy(fs, flags, nil)
+
+	if err := server.Reload(); err != nil {
+		log.Fatal("Configuration validation failed", log.Err(err))
+	}
+
+	// Method details need API Gateway, offline routes are listed without them
+	describe := proxy.DescribeRouteMethods
+	if flags.Offline {
+		describe = proxy.DescribeRoutes
+	}
+
+	routes, err := describe(server.HandlerMapping())
+	if err != nil {
+		log.Fatal("Failed to describe routes", log.Err(err))
+	}
+
+	var out io.Writer = os.Stdout
+	if flags.OutputFile != "" {
+		file, err := fs.Create(flags.OutputFile)
+		if err != nil {
+			log.Fatal("Failed to create output file", log.Err(err))
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				log.Fatal("Failed to close output file", log.Err(err), log.String("file", flags.OutputFile))
+			}
+		}()
+		out = file
+	}
+
+	if err := proxy.WriteRoutes(out, routes, proxy.RouteFormat(flags.Output)); err != nil {
+		log.Fatal("Failed to write routes", log.Err(err))
+	}
+}
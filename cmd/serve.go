@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/oscarbc96/agbridge/pkg/auth"
+	"github.com/oscarbc96/agbridge/pkg/certs"
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/oscarbc96/agbridge/pkg/proxy"
+	"github.com/oscarbc96/agbridge/pkg/tracing"
+	"github.com/samber/lo"
+	"github.com/spf13/afero"
+)
+
+func loadTLSConfig(fs afero.Fs, flags *Flags) (*tls.Config, error) {
+	certFile, keyFile := flags.TLSCert, flags.TLSKey
+
+	if flags.TLSSelfSigned {
+		dir := flags.TLSDir
+		if dir == "" {
+			defaultDir, err := certs.DefaultDir()
+			if err != nil {
+				return nil, err
+			}
+			dir = defaultDir
+		}
+
+		hostnames := flags.TLSHostnames
+		if hostnames == nil {
+			hostnames = certs.DefaultHostnames
+		}
+
+		files, created, err := certs.SelfSigned(fs, dir, hostnames)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up self-signed certificates: %w", err)
+		}
+
+		if created {
+			log.Info("Created local CA", log.String("ca", files.CACert))
+			fmt.Println(certs.TrustInstructions(files.CACert, lo.FirstOr(flags.ListenAddresses, ""), hostnames[0]))
+		}
+		log.Info("Using self-signed certificate", log.String("cert", files.Cert), log.Any("hostnames", hostnames))
+
+		certFile, keyFile = files.Cert, files.Key
+	}
+
+	if certFile == "" {
+		return nil, nil
+	}
+
+	return certs.ServerConfig(fs, certFile, keyFile, flags.TLSClientCA)
+}
+
+// newProxy loads the configuration and returns a proxy listening on the
+// addresses, with its authentication and redaction set up.
+func newProxy(fs afero.Fs, flags *Flags, listenAddresses []string) *proxy.Proxy {
+	cfg, err := loadProxyConfig(fs, flags)
+	if err != nil {
+		log.Fatal("Failed to load configuration", log.Err(err))
+	}
+
+	redactor, err := log.NewRedactor(cfg.Redaction)
+	if err != nil {
+		log.Fatal("Invalid redaction configuration", log.Err(err))
+	}
+	log.SetRedactor(redactor)
+
+	if err := cfg.ValidateListenAddresses(listenAddresses); err != nil {
+		log.Fatal("Invalid listen addresses", log.Err(err))
+	}
+
+	server := proxy.NewProxy(listenAddresses, cfg)
+
+	if flags.RouteCache != "" {
+		server.UseRouteCache(proxy.NewRouteCache(fs, flags.RouteCache), flags.Offline)
+	}
+
+	// Client certificates are only verified, and so accepted, when serving
+	// with a client CA
+	if len(listenAddresses) > 0 && len(cfg.Auth.ClientCertCommonNames) > 0 && flags.TLSClientCA == "" {
+		log.Fatal("Invalid authentication configuration", log.Err(errors.New("`client_cert_common_names` requires `--tls-client-ca`")))
+	}
+
+	if cfg.Auth.Enabled() {
+		authenticator, err := auth.New(fs, cfg.Auth)
+		if err != nil {
+			log.Fatal("Failed to set up authentication", log.Err(err))
+		}
+		server.UseAuth(authenticator)
+	}
+
+	return server
+}
+
+func runServe(fs afero.Fs, flags *Flags) {
+	if flags.AccessLog != "" {
+		log.SetupAccess(flags.AccessLogFormat, log.Output(flags.AccessLog, flags.LogMaxSize))
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), flags.OTLPEndpoint, version)
+	if err != nil {
+		log.Fatal("Failed to set up tracing", log.Err(err))
+	}
+
+	server := newProxy(fs, flags, flags.ListenAddresses)
+
+	tlsConfig, err := loadTLSConfig(fs, flags)
+	if err != nil {
+		log.Fatal("Failed to load TLS configuration", log.Err(err))
+	}
+	if tlsConfig != nil {
+		server.UseTLS(tlsConfig)
+	}
+
+	server.SetLoadWait(flags.LoadWait)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Listen before starting, so the addresses report the ports chosen for :0
+	if err := server.Listen(); err != nil {
+		log.Fatal("Failed to listen", log.Err(err))
+	}
+
+	go func() {
+		log.Info("Starting proxy server", log.Any("addresses", server.Addrs()))
+		if err := server.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Proxy server encountered an error", log.Err(err))
+		}
+	}()
+
+	// Load the routes while serving, requests wait for them up to the load
+	// wait. Cached routes are served until they are revalidated.
+	go func() {
+		start := time.Now()
+		cached := flags.RouteCache != "" && !flags.Offline
+		if cached {
+			if err := server.LoadCachedRoutes(); err != nil {
+				log.Info("Resolving routes without the route cache", log.Err(err))
+				cached = false
+			} else {
+				routesLoaded(fs, flags, server, start)
+			}
+		}
+
+		if err := server.Reload(); err != nil {
+			if cached {
+				log.Error("Failed to revalidate cached routes", log.Err(err))
+				return
+			}
+			// The gateways loaded are served, the failing ones are reported
+			// by the readiness endpoint
+			log.Error("Failed to load the routes of some gateways", log.Err(err))
+		}
+
+		if cached {
+			log.Info("Revalidated cached routes", log.Int("routes", len(server.HandlerMapping())), log.Duration("elapsed_ms", time.Since(start)))
+			return
+		}
+		routesLoaded(fs, flags, server, start)
+	}()
+
+	if flags.ReadyFile != "" {
+		defer func() {
+			if err := fs.Remove(flags.ReadyFile); err != nil && !os.IsNotExist(err) {
+				log.Error("Failed to remove ready file", log.Err(err), log.String("file", flags.ReadyFile))
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	log.Info("Shutdown signal received, stopping proxy server...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Fatal("Failed to stop proxy server gracefully", log.Err(err))
+	} else {
+		log.Info("Proxy server stopped successfully")
+	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Error("Failed to flush traces", log.Err(err))
+	}
+}
+
+// routesLoaded prints the routes loaded for the first time and writes the
+// ready file.
+func routesLoaded(fs afero.Fs, flags *Flags, server *proxy.Proxy, start time.Time) {
+	log.Info("Routes loaded", log.Int("routes", len(server.HandlerMapping())), log.Duration("elapsed_ms", time.Since(start)))
+
+	// The proxy is already serving, failing to print the routes must not
+	// stop it
+	if err := proxy.PrintMappings(server.HandlerMapping()); err != nil {
+		log.Error("Failed to print mappings", log.Err(err))
+	}
+
+	if flags.ReadyFile != "" {
+		if err := checkReady(server.Gateways()); err != nil {
+			log.Error("Not writing the ready file", log.Err(err), log.String("file", flags.ReadyFile))
+			return
+		}
+		if err := writeReadyFile(fs, flags.ReadyFile, newReadyFile(server, os.Getpid())); err != nil {
+			log.Fatal("Failed to write ready file", log.Err(err), log.String("file", flags.ReadyFile))
+		}
+	}
+}
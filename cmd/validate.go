@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/oscarbc96/agbridge/pkg/proxy"
+	"github.com/samber/lo"
+	"github.com/spf13/afero"
+)
+
+func runValidate(fs afero.Fs, flags *Flags) {
+	server := newProxy(fs, flags, nil)
+	checks := server.Preflight()
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Rest API ID", "Stage", "Check", "Result", "Detail", "Hint"})
+	for _, check := range checks {
+		t.AppendRow(table.Row{check.RestAPIID, check.StageName, check.Name, lo.Ternary(check.Passed, "PASS", "FAIL"), check.Detail, check.Hint})
+	}
+	t.SetColumnConfigs([]table.ColumnConfig{
+		{Name: "Rest API ID", AutoMerge: true},
+		{Name: "Stage", AutoMerge: true},
+		{Name: "Detail", WidthMax: 60},
+		{Name: "Hint", WidthMax: 60},
+	})
+	t.SetStyle(table.StyleLight)
+	t.Render()
+
+	failed := lo.CountBy(checks, func(check proxy.PreflightCheck) bool { return !check.Passed })
+	if failed > 0 {
+		log.Fatal("Configuration validation failed", log.Int("failed_checks", failed))
+	}
+	log.Info("Configuration is valid")
+}
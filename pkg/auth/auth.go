@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/afero"
+)
+
+const (
+	MethodToken      = "token"
+	MethodBasic      = "basic"
+	MethodClientCert = "client_cert"
+	MethodJWT        = "jwt"
+
+	defaultPrincipalClaim = "sub"
+	anyCommonName         = "*"
+)
+
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Name   string
+	Method string
+}
+
+type Authenticator struct {
+	config Config
+	keys   *keySet
+	parser *jwt.Parser
+}
+
+func New(fs afero.Fs, config Config) (*Authenticator, error) {
+	a := &Authenticator{config: config}
+
+	if config.JWT != nil {
+		keys, err := newKeySet(fs, config.JWT.JWKSFile, config.JWT.JWKSURL)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = keys
+
+		options := []jwt.ParserOption{
+			jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+			jwt.WithExpirationRequired(),
+		}
+		if config.JWT.Issuer != "" {
+			options = append(options, jwt.WithIssuer(config.JWT.Issuer))
+		}
+		if config.JWT.Audience != "" {
+			options = append(options, jwt.WithAudience(config.JWT.Audience))
+		}
+		a.parser = jwt.NewParser(options...)
+	}
+
+	return a, nil
+}
+
+// Authenticate identifies the caller of r with the first configured method
+// its credentials match.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if principal := a.clientCert(r); principal != nil {
+		return principal, nil
+	}
+
+	scheme, credentials, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		if principal := a.token(credentials); principal != nil {
+			return principal, nil
+		}
+		if a.parser != nil {
+			return a.jwt(credentials)
+		}
+		return nil, errors.New("invalid bearer token")
+	case "basic":
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			return nil, errors.New("malformed basic credentials")
+		}
+		if principal := a.basic(username, password); principal != nil {
+			return principal, nil
+		}
+		return nil, fmt.Errorf("invalid basic credentials for user %s", username)
+	default:
+		return nil, fmt.Errorf("unsupported authorization scheme %s", scheme)
+	}
+}
+
+// Challenge returns the WWW-Authenticate header value for the configured
+// methods.
+func (a *Authenticator) Challenge() string {
+	var challenges []string
+	if len(a.config.Tokens) > 0 || a.config.JWT != nil {
+		challenges = append(challenges, `Bearer realm="agbridge"`)
+	}
+	if len(a.config.Basic) > 0 {
+		challenges = append(challenges, `Basic realm="agbridge"`)
+	}
+	return strings.Join(challenges, ", ")
+}
+
+func (a *Authenticator) clientCert(r *http.Request) *Principal {
+	if len(a.config.ClientCertCommonNames) == 0 || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if !slices.Contains(a.config.ClientCertCommonNames, anyCommonName) && !slices.Contains(a.config.ClientCertCommonNames, cn) {
+		return nil
+	}
+
+	return &Principal{Name: cn, Method: MethodClientCert}
+}
+
+func (a *Authenticator) token(token string) *Principal {
+	for _, t := range a.config.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			return &Principal{Name: t.Name, Method: MethodToken}
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) basic(username, password string) *Principal {
+	for _, b := range a.config.Basic {
+		userMatch := subtle.ConstantTimeCompare([]byte(b.Username), []byte(username))
+		passwordMatch := subtle.ConstantTimeCompare([]byte(b.Password), []byte(password))
+		if userMatch&passwordMatch == 1 {
+			return &Principal{Name: b.Username, Method: MethodBasic}
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) jwt(raw string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return a.keys.key(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT: %w", err)
+	}
+
+	claim := a.config.JWT.PrincipalClaim
+	if claim == "" {
+		claim = defaultPrincipalClaim
+	}
+
+	name, ok := claims[claim].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid JWT: missing %s claim", claim)
+	}
+
+	return &Principal{Name: name, Method: MethodJWT}, nil
+}
+
+// Allowed reports whether the principal is in the allow list. An empty
+// allow list accepts every authenticated principal.
+func Allowed(principal *Principal, allowList []string) bool {
+	if len(allowList) == 0 {
+		return true
+	}
+	return principal != nil && slices.Contains(allowList, principal.Name)
+}
+
+type principalKey struct{}
+
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal authenticated for the request, or nil
+// when authentication is disabled.
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
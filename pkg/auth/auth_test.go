@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeJWKS(t *testing.T, fs afero.Fs, kid string, key *ecdsa.PrivateKey) {
+	t.Helper()
+
+	jwks, err := json.Marshal(map[string]any{
+		"keys": []map[string]string{{
+			"kid": kid,
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, afero.WriteFile(fs, "jwks.json", jwks, 0o644))
+}
+
+func signJWT(t *testing.T, kid string, key *ecdsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	fs := afero.NewMemMapFs()
+	writeJWKS(t, fs, "key-1", key)
+
+	authenticator, err := New(fs, Config{
+		Tokens:                []TokenConfig{{Name: "ci", Token: "s3cr3t"}},
+		Basic:                 []BasicConfig{{Username: "alice", Password: "hunter2"}},
+		ClientCertCommonNames: []string{"runner"},
+		JWT:                   &JWTConfig{JWKSFile: "jwks.json", Issuer: "https://issuer", Audience: "agbridge"},
+	})
+	require.NoError(t, err)
+
+	validClaims := jwt.MapClaims{
+		"sub": "bob",
+		"iss": "https://issuer",
+		"aud": "agbridge",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	expiredClaims := jwt.MapClaims{
+		"sub": "bob",
+		"iss": "https://issuer",
+		"aud": "agbridge",
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	}
+
+	tests := []struct {
+		name         string
+		setup        func(r *http.Request)
+		expPrincipal *Principal
+		expErr       bool
+	}{
+		{
+			name:   "No credentials",
+			setup:  func(r *http.Request) {},
+			expErr: true,
+		},
+		{
+			name:         "Valid token",
+			setup:        func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cr3t") },
+			expPrincipal: &Principal{Name: "ci", Method: MethodToken},
+		},
+		{
+			name:   "Invalid token",
+			setup:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") },
+			expErr: true,
+		},
+		{
+			name:         "Valid basic",
+			setup:        func(r *http.Request) { r.SetBasicAuth("alice", "hunter2") },
+			expPrincipal: &Principal{Name: "alice", Method: MethodBasic},
+		},
+		{
+			name:   "Invalid basic",
+			setup:  func(r *http.Request) { r.SetBasicAuth("alice", "wrong") },
+			expErr: true,
+		},
+		{
+			name: "Valid client certificate",
+			setup: func(r *http.Request) {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "runner"}}}}}
+			},
+			expPrincipal: &Principal{Name: "runner", Method: MethodClientCert},
+		},
+		{
+			name: "Client certificate not allowed",
+			setup: func(r *http.Request) {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "laptop"}}}}}
+			},
+			expErr: true,
+		},
+		{
+			name: "Valid JWT",
+			setup: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer "+signJWT(t, "key-1", key, validClaims))
+			},
+			expPrincipal: &Principal{Name: "bob", Method: MethodJWT},
+		},
+		{
+			name: "Expired JWT",
+			setup: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer "+signJWT(t, "key-1", key, expiredClaims))
+			},
+			expErr: true,
+		},
+		{
+			name: "JWT signed by unknown key",
+			setup: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer "+signJWT(t, "key-1", otherKey, validClaims))
+			},
+			expErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			tt.setup(r)
+
+			principal, err := authenticator.Authenticate(r)
+			if tt.expErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expPrincipal, principal)
+		})
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	t.Parallel()
+
+	principal := &Principal{Name: "ci", Method: MethodToken}
+
+	assert.True(t, Allowed(principal, nil))
+	assert.True(t, Allowed(principal, []string{"alice", "ci"}))
+	assert.False(t, Allowed(principal, []string{"alice"}))
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, Config{
+		Tokens: []TokenConfig{{Name: "ci", Token: "s3cr3t"}},
+		Basic:  []BasicConfig{{Username: "alice", Password: "hunter2"}},
+	}.Validate())
+
+	require.EqualError(t, Config{Tokens: []TokenConfig{{Name: "ci"}}}.Validate(), `auth token "ci" must not be empty`)
+	require.EqualError(t, Config{Basic: []BasicConfig{{Username: "alice"}}}.Validate(), `auth basic password for "alice" must not be empty`)
+	require.EqualError(t, Config{Basic: []BasicConfig{{Password: "hunter2"}}}.Validate(), "auth basic username must not be empty")
+}
+
+func TestConfigRedacted(t *testing.T) {
+	t.Parallel()
+
+	config := Config{
+		Tokens: []TokenConfig{{Name: "ci", Token: "s3cr3t"}},
+		Basic:  []BasicConfig{{Username: "alice", Password: "hunter2"}},
+	}
+
+	redacted := config.Redacted()
+
+	assert.Equal(t, "ci", redacted.Tokens[0].Name)
+	assert.NotEqual(t, "s3cr3t", redacted.Tokens[0].Token)
+	assert.Equal(t, "alice", redacted.Basic[0].Username)
+	assert.NotEqual(t, "hunter2", redacted.Basic[0].Password)
+	assert.Equal(t, "s3cr3t", config.Tokens[0].Token, "original config must not be modified")
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/oscarbc96/agbridge/pkg/log"
+)
+
+type TokenConfig struct {
+	// Name identifies the principal authenticated by the token.
+	Name  string `yaml:"name" json:"name"`
+	Token string `yaml:"token" json:"token"`
+}
+
+type BasicConfig struct {
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"password"`
+}
+
+type JWTConfig struct {
+	// JWKSFile and JWKSURL locate the keys that sign the tokens, only one of
+	// them can be set.
+	JWKSFile string `yaml:"jwks_file" json:"jwks_file,omitempty"`
+	JWKSURL  string `yaml:"jwks_url" json:"jwks_url,omitempty"`
+	Issuer   string `yaml:"issuer" json:"issuer,omitempty"`
+	Audience string `yaml:"audience" json:"audience,omitempty"`
+	// PrincipalClaim names the claim identifying the principal, `sub` by
+	// default.
+	PrincipalClaim string `yaml:"principal_claim" json:"principal_claim,omitempty"`
+}
+
+type Config struct {
+	Tokens []TokenConfig `yaml:"tokens" json:"tokens,omitempty"`
+	Basic  []BasicConfig `yaml:"basic" json:"basic,omitempty"`
+	// ClientCertCommonNames accepts the verified client certificates with one
+	// of these common names, or any verified certificate with `*`.
+	ClientCertCommonNames []string   `yaml:"client_cert_common_names" json:"client_cert_common_names,omitempty"`
+	JWT                   *JWTConfig `yaml:"jwt" json:"jwt,omitempty"`
+}
+
+// Enabled reports whether callers must authenticate.
+func (c Config) Enabled() bool {
+	return len(c.Tokens) > 0 || len(c.Basic) > 0 || len(c.ClientCertCommonNames) > 0 || c.JWT != nil
+}
+
+// Validate rejects the credentials that would accept anyone, like empty
+// tokens.
+func (c Config) Validate() error {
+	for _, token := range c.Tokens {
+		if token.Token == "" {
+			return fmt.Errorf("auth token %q must not be empty", token.Name)
+		}
+	}
+
+	for _, basic := range c.Basic {
+		if basic.Username == "" {
+			return errors.New("auth basic username must not be empty")
+		}
+		if basic.Password == "" {
+			return fmt.Errorf("auth basic password for %q must not be empty", basic.Username)
+		}
+	}
+
+	return nil
+}
+
+// Redacted returns a copy of the configuration without secrets.
+func (c Config) Redacted() Config {
+	redacted := c
+
+	redacted.Tokens = make([]TokenConfig, len(c.Tokens))
+	for i, token := range c.Tokens {
+		redacted.Tokens[i] = TokenConfig{Name: token.Name, Token: log.Redacted}
+	}
+
+	redacted.Basic = make([]BasicConfig, len(c.Basic))
+	for i, basic := range c.Basic {
+		redacted.Basic[i] = BasicConfig{Username: basic.Username, Password: log.Redacted}
+	}
+
+	return redacted
+}
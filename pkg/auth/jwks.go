@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/spf13/afero"
+)
+
+// jwksRefreshInterval limits how often the key set is fetched again when a
+// token is signed by an unknown key.
+const jwksRefreshInterval = time.Minute
+
+// errUnsupportedKey reports a key that can't verify tokens, like the
+// encryption keys published in the same set.
+var errUnsupportedKey = errors.New("unsupported key")
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type keySet struct {
+	fs     afero.Fs
+	file   string
+	url    string
+	client *http.Client
+
+	// refreshMu serializes refreshes, mu guards the keys without being held
+	// while they are fetched, so known keys are never blocked by a refresh.
+	refreshMu sync.Mutex
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	fetched   time.Time
+}
+
+func newKeySet(fs afero.Fs, file, url string) (*keySet, error) {
+	if (file == "") == (url == "") {
+		return nil, errors.New("exactly one of jwks_file or jwks_url must be set")
+	}
+
+	ks := &keySet{fs: fs, file: file, url: url, client: &http.Client{Timeout: 10 * time.Second}}
+	if err := ks.refresh(); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// key returns the public key with the given ID. Unknown keys trigger a
+// refresh of key sets fetched from a URL, as they are rotated.
+func (ks *keySet) key(kid string) (crypto.PublicKey, error) {
+	key, ok, stale := ks.lookup(kid)
+	if ok {
+		return key, nil
+	}
+
+	if ks.url != "" && stale {
+		ks.refreshMu.Lock()
+		defer ks.refreshMu.Unlock()
+
+		// Another request may have refreshed the keys while waiting
+		if key, ok, stale = ks.lookup(kid); ok {
+			return key, nil
+		}
+		if stale {
+			if err := ks.refreshLocked(); err != nil {
+				return nil, err
+			}
+			if key, ok, _ := ks.lookup(kid); ok {
+				return key, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup returns the key with the given ID, and whether the keys can be
+// refreshed again.
+func (ks *keySet) lookup(kid string) (crypto.PublicKey, bool, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, ok := ks.keys[kid]
+	return key, ok, time.Since(ks.fetched) > jwksRefreshInterval
+}
+
+func (ks *keySet) refresh() error {
+	ks.refreshMu.Lock()
+	defer ks.refreshMu.Unlock()
+	return ks.refreshLocked()
+}
+
+// refreshLocked loads the keys again, with refreshMu held.
+func (ks *keySet) refreshLocked() error {
+	var (
+		data []byte
+		err  error
+	)
+	if ks.file != "" {
+		data, err = afero.ReadFile(ks.fs, ks.file)
+	} else {
+		data, err = ks.fetch()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load JWKS: %w", err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.fetched = time.Now()
+	ks.mu.Unlock()
+
+	return nil
+}
+
+func (ks *keySet) fetch() ([]byte, error) {
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s from %s", resp.Status, ks.url)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if errors.Is(err, errUnsupportedKey) {
+			log.Debug("Skipping JWKS key", log.String("kid", k.Kid), log.Err(err))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in JWKS: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: curve %q", errUnsupportedKey, k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("%w: curve %q", errUnsupportedKey, k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("%w: type %q", errUnsupportedKey, k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
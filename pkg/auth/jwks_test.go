@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySetRefresh(t *testing.T) {
+	t.Parallel()
+
+	jwk := func(kid string) map[string]string {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		return map[string]string{
+			"kid": kid,
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}
+	}
+	key1, key2 := jwk("key-1"), jwk("key-2")
+
+	var fetches atomic.Int32
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := []map[string]string{key1}
+		// Refreshes wait to be released, with the rotated key
+		if fetches.Add(1) > 1 {
+			close(fetching)
+			<-release
+			keys = append(keys, key2)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(server.Close)
+
+	ks, err := newKeySet(afero.NewMemMapFs(), "", server.URL)
+	require.NoError(t, err)
+
+	// Unknown keys aren't fetched again until the refresh interval passes
+	_, err = ks.key("key-2")
+	require.EqualError(t, err, `unknown signing key "key-2"`)
+	assert.Equal(t, int32(1), fetches.Load())
+
+	ks.mu.Lock()
+	ks.fetched = time.Now().Add(-2 * jwksRefreshInterval)
+	ks.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ks.key("key-2")
+			assert.NoError(t, err)
+		}()
+	}
+
+	// Known keys are served while the key set is being fetched
+	<-fetching
+	known := make(chan error, 1)
+	go func() {
+		_, err := ks.key("key-1")
+		known <- err
+	}()
+	select {
+	case err := <-known:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("known key blocked by the refresh")
+	}
+
+	close(release)
+	wg.Wait()
+
+	// Concurrent lookups of the rotated key fetch the key set once
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestParseJWKSSkipsUnsupportedKeys(t *testing.T) {
+	t.Parallel()
+
+	data := `{"keys": [
+		{"kid": "sig", "kty": "OKP", "crv": "Ed25519", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+		{"kid": "enc", "kty": "OKP", "crv": "X25519", "x": "hSDwCYkwp1R0i33ctD73Wg2_Og0mOBr066SpjqqbTmo"},
+		{"kid": "sym", "kty": "oct", "k": "c2VjcmV0"}
+	]}`
+
+	keys, err := parseJWKS([]byte(data))
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+	assert.Contains(t, keys, "sig")
+
+	_, err = parseJWKS([]byte(`{"keys": [{"kid": "bad", "kty": "RSA", "n": "!", "e": "AQAB"}]}`))
+	require.ErrorContains(t, err, `invalid key "bad" in JWKS`)
+}
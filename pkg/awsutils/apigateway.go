@@ -2,11 +2,14 @@ package awsutils
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/apigateway"
 	"github.com/aws/aws-sdk-go-v2/service/apigateway/types"
+	"github.com/aws/smithy-go"
 )
 
 func DescribeAPIGateway(config aws.Config, apiID string) ([]types.Resource, error) {
@@ -42,3 +45,131 @@ func DescribeStage(config aws.Config, apiID, stageName string) (*apigateway.GetS
 
 	return stageOutput, nil
 }
+
+func ListRestAPIs(config aws.Config) ([]types.RestApi, error) {
+	client := apigateway.NewFromConfig(config)
+
+	var result []types.RestApi
+	ctx := context.TODO()
+	paginator := apigateway.NewGetRestApisPaginator(client, &apigateway.GetRestApisInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Rest APIs: %w", err)
+		}
+		result = append(result, page.Items...)
+	}
+
+	return result, nil
+}
+
+func ListStages(config aws.Config, apiID string) ([]types.Stage, error) {
+	client := apigateway.NewFromConfig(config)
+
+	stagesOutput, err := client.GetStages(context.TODO(), &apigateway.GetStagesInput{
+		RestApiId: aws.String(apiID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stages: %w", err)
+	}
+
+	return stagesOutput.Item, nil
+}
+
+func DescribeMethod(config aws.Config, apiID, resourceID, httpMethod string) (*apigateway.GetMethodOutput, error) {
+	client := apigateway.NewFromConfig(config)
+
+	methodOutput, err := client.GetMethod(context.TODO(), &apigateway.GetMethodInput{
+		RestApiId:  aws.String(apiID),
+		ResourceId: aws.String(resourceID),
+		HttpMethod: aws.String(httpMethod),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get method: %w", err)
+	}
+
+	return methodOutput, nil
+}
+
+func DescribeRestAPI(config aws.Config, apiID string) (*apigateway.GetRestApiOutput, error) {
+	client := apigateway.NewFromConfig(config)
+
+	restAPIOutput, err := client.GetRestApi(context.TODO(), &apigateway.GetRestApiInput{
+		RestApiId: aws.String(apiID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Rest API: %w", err)
+	}
+
+	return restAPIOutput, nil
+}
+
+// Partition returns the partition of the ARNs in the region, like `aws-cn`
+// for the China regions.
+func Partition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "us-isob-"):
+		return "aws-iso-b"
+	case strings.HasPrefix(region, "us-iso-"):
+		return "aws-iso"
+	default:
+		return "aws"
+	}
+}
+
+// TestInvokeARN returns the ARN of the TestInvokeMethod calls to any method of
+// the Rest API.
+func TestInvokeARN(region, apiID string) string {
+	return fmt.Sprintf("arn:%s:apigateway:%s::/restapis/%s/resources/*/methods/*", Partition(region), region, apiID)
+}
+
+// probeResourceID doesn't exist in any Rest API, API Gateway authorizes
+// requests before looking resources up so calls using it don't change or
+// invoke anything.
+const probeResourceID = "agbridge-probe"
+
+// ProbeTestInvoke calls GetMethod (apigateway:GET) and TestInvokeMethod
+// (apigateway:POST) on a resource that doesn't exist and returns the actions
+// the caller is denied. Not found errors mean the action is allowed.
+func ProbeTestInvoke(config aws.Config, apiID string) ([]string, error) {
+	client := apigateway.NewFromConfig(config)
+	ctx := context.TODO()
+
+	_, getErr := client.GetMethod(ctx, &apigateway.GetMethodInput{
+		RestApiId:  aws.String(apiID),
+		ResourceId: aws.String(probeResourceID),
+		HttpMethod: aws.String("GET"),
+	})
+	_, postErr := client.TestInvokeMethod(ctx, &apigateway.TestInvokeMethodInput{
+		RestApiId:  aws.String(apiID),
+		ResourceId: aws.String(probeResourceID),
+		HttpMethod: aws.String("GET"),
+	})
+
+	probes := []struct {
+		action string
+		err    error
+	}{
+		{action: "apigateway:GET", err: getErr},
+		{action: "apigateway:POST", err: postErr},
+	}
+
+	var denied []string
+	for _, probe := range probes {
+		action, err := probe.action, probe.err
+		var notFound *types.NotFoundException
+		var apiErr smithy.APIError
+		switch {
+		case err == nil, errors.As(err, &notFound):
+		case errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDeniedException":
+			denied = append(denied, action)
+		default:
+			return nil, fmt.Errorf("failed to probe %s: %w", action, err)
+		}
+	}
+	return denied, nil
+}
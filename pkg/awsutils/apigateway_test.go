@@ -5,6 +5,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/service/apigateway"
 	"github.com/oscarbc96/agbridge/internal/testutil"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -38,6 +39,31 @@ func (suite *APIGatewayTestSuite) TestDescribeAPIGateway_EmptyID() {
 	suite.Require().Error(err, "expected error with empty API Gateway ID")
 }
 
+func (suite *APIGatewayTestSuite) TestDescribeMethod_MissingMethod() {
+	_, err := DescribeMethod(*suite.Config, *suite.ApiGateway.Id, *suite.ApiGateway.RootResourceId, "GET")
+	suite.Require().Error(err, "expected error with a method that doesn't exist")
+}
+
 func TestAPIGatewayTestSuite(t *testing.T) {
 	suite.Run(t, new(APIGatewayTestSuite))
 }
+
+func TestTestInvokeARN(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		region string
+		exp    string
+	}{
+		{region: "eu-west-1", exp: "arn:aws:apigateway:eu-west-1::/restapis/abc123/resources/*/methods/*"},
+		{region: "cn-north-1", exp: "arn:aws-cn:apigateway:cn-north-1::/restapis/abc123/resources/*/methods/*"},
+		{region: "us-gov-west-1", exp: "arn:aws-us-gov:apigateway:us-gov-west-1::/restapis/abc123/resources/*/methods/*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.region, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.exp, TestInvokeARN(tt.region, "abc123"))
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 func LoadConfigFor(profile, region string) (*aws.Config, error) {
@@ -29,3 +31,21 @@ func LoadConfigFor(profile, region string) (*aws.Config, error) {
 
 	return &cfg, nil
 }
+
+// AssumeRole returns a copy of config whose credentials are those of roleARN,
+// assumed with the credentials of config and refreshed before they expire.
+func AssumeRole(config aws.Config, roleARN, externalID, sessionName string) aws.Config {
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(config), roleARN, func(o *stscreds.AssumeRoleOptions) {
+		if externalID != "" {
+			o.ExternalID = aws.String(externalID)
+		}
+		if sessionName != "" {
+			o.RoleSessionName = sessionName
+		}
+	})
+
+	assumed := config.Copy()
+	assumed.Credentials = aws.NewCredentialsCache(provider)
+
+	return assumed
+}
@@ -0,0 +1,72 @@
+package awsutils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// RoleName returns the name of the role of the caller ARN returned by STS for
+// assumed role sessions.
+func RoleName(callerARN string) (string, bool) {
+	_, rest, ok := strings.Cut(callerARN, ":assumed-role/")
+	if !ok {
+		return "", false
+	}
+
+	role, _, _ := strings.Cut(rest, "/")
+	return role, true
+}
+
+// PrincipalARN returns the IAM ARN to simulate policies for the caller ARN
+// returned by STS. The ARN of the role of assumed role sessions is looked up
+// with iam:GetRole, as it includes the path of the role, like the
+// /aws-reserved/sso.amazonaws.com/ path of IAM Identity Center roles.
+func PrincipalARN(config aws.Config, callerARN string) (string, error) {
+	role, ok := RoleName(callerARN)
+	if !ok {
+		return callerARN, nil
+	}
+
+	client := iam.NewFromConfig(config)
+	output, err := client.GetRole(context.TODO(), &iam.GetRoleInput{RoleName: aws.String(role)})
+	if err != nil {
+		return "", fmt.Errorf("failed to get role %s: %w", role, err)
+	}
+
+	return aws.ToString(output.Role.Arn), nil
+}
+
+// SimulatePolicy simulates the policies of the principal for the actions on
+// the resource and returns the actions that aren't allowed.
+func SimulatePolicy(config aws.Config, principalARN string, actions []string, resourceARN string) ([]string, error) {
+	client := iam.NewFromConfig(config)
+
+	var denied []string
+	ctx := context.TODO()
+	paginator := iam.NewSimulatePrincipalPolicyPaginator(client, &iam.SimulatePrincipalPolicyInput{
+		PolicySourceArn: aws.String(principalARN),
+		ActionNames:     actions,
+		ResourceArns:    []string{resourceARN},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to simulate principal policy: %w", err)
+		}
+
+		for _, result := range page.EvaluationResults {
+			if result.EvalDecision != types.PolicyEvaluationDecisionTypeAllowed {
+				denied = append(denied, aws.ToString(result.EvalActionName))
+			}
+		}
+	}
+	sort.Strings(denied)
+
+	return denied, nil
+}
@@ -0,0 +1,31 @@
+package awsutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		callerARN string
+		exp       string
+		expOK     bool
+	}{
+		{callerARN: "arn:aws:iam::123456789012:user/dev", exp: "", expOK: false},
+		{callerARN: "arn:aws:sts::123456789012:assumed-role/Developer/dev-session", exp: "Developer", expOK: true},
+		{callerARN: "arn:aws:sts::123456789012:assumed-role/Developer", exp: "Developer", expOK: true},
+		{callerARN: "arn:aws:sts::123456789012:assumed-role/AWSReservedSSO_Admin_0123456789abcdef/dev@example.com", exp: "AWSReservedSSO_Admin_0123456789abcdef", expOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.callerARN, func(t *testing.T) {
+			t.Parallel()
+			role, ok := RoleName(tt.callerARN)
+			assert.Equal(t, tt.exp, role)
+			assert.Equal(t, tt.expOK, ok)
+		})
+	}
+}
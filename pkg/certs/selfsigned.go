@@ -0,0 +1,270 @@
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/oscarbc96/agbridge/pkg/proxy"
+	"github.com/spf13/afero"
+)
+
+const (
+	CAFileName   = "ca.pem"
+	CAKeyName    = "ca-key.pem"
+	CertFileName = "cert.pem"
+	KeyFileName  = "key.pem"
+
+	caValidity = 10 * 365 * 24 * time.Hour
+	// Clients like macOS reject leaf certificates valid for longer than 825 days.
+	leafValidity = 825 * 24 * time.Hour
+	// Leaf certificates expiring sooner than this are issued again.
+	renewBefore = 30 * 24 * time.Hour
+)
+
+var DefaultHostnames = []string{"localhost", "127.0.0.1", "::1"}
+
+// Files are the paths of a local CA and the leaf certificate it issued.
+type Files struct {
+	CACert string
+	Cert   string
+	Key    string
+}
+
+// DefaultDir returns the directory where self-signed certificates persist
+// between runs.
+func DefaultDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user config directory: %w", err)
+	}
+	return filepath.Join(dir, "agbridge", "tls"), nil
+}
+
+// SelfSigned makes sure dir holds a local CA and a leaf certificate, signed
+// by it, valid for every host in hosts. Existing files are reused while they
+// are still valid. The returned bool reports whether the CA was created.
+func SelfSigned(fs afero.Fs, dir string, hosts []string) (*Files, bool, error) {
+	if len(hosts) == 0 || slices.Contains(hosts, "") {
+		return nil, false, errors.New("self-signed certificates require non-empty hostnames")
+	}
+
+	files := &Files{
+		CACert: filepath.Join(dir, CAFileName),
+		Cert:   filepath.Join(dir, CertFileName),
+		Key:    filepath.Join(dir, KeyFileName),
+	}
+
+	if err := fs.MkdirAll(dir, 0o700); err != nil {
+		return nil, false, fmt.Errorf("failed to create certificates directory %s: %w", dir, err)
+	}
+
+	ca, caKey, err := loadKeyPair(fs, files.CACert, filepath.Join(dir, CAKeyName))
+	caCreated := false
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, false, fmt.Errorf("failed to load local CA: %w", err)
+		}
+
+		ca, caKey, err = createCA(fs, files.CACert, filepath.Join(dir, CAKeyName))
+		if err != nil {
+			return nil, false, err
+		}
+		caCreated = true
+	}
+
+	leaf, _, err := loadKeyPair(fs, files.Cert, files.Key)
+	if err == nil && !caCreated && leafCovers(leaf, ca, hosts) {
+		return files, false, nil
+	}
+
+	if err := createLeaf(fs, files.Cert, files.Key, ca, caKey, hosts); err != nil {
+		return nil, false, err
+	}
+
+	return files, caCreated, nil
+}
+
+// TrustInstructions explains how to make clients trust the local CA, with a
+// curl example calling the proxy listening on listenAddress as hostname.
+func TrustInstructions(caFile, listenAddress, hostname string) string {
+	host := hostname
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	curl := fmt.Sprintf("curl --cacert %s https://%s/", caFile, host)
+	if path, ok := strings.CutPrefix(listenAddress, proxy.UnixSocketScheme); ok {
+		curl = fmt.Sprintf("curl --cacert %s --unix-socket %s https://%s/", caFile, path, host)
+	} else if _, port, err := net.SplitHostPort(listenAddress); err == nil && port != "443" {
+		curl = fmt.Sprintf("curl --cacert %s https://%s/", caFile, net.JoinHostPort(hostname, port))
+	}
+
+	return fmt.Sprintf(`Clients must trust the local CA at %[1]s, for example:
+  macOS:  sudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain %[1]s
+  Linux:  sudo cp %[1]s /usr/local/share/ca-certificates/agbridge.crt && sudo update-ca-certificates
+  curl:   %[2]s
+  Node:   NODE_EXTRA_CA_CERTS=%[1]s npm start`, caFile, curl)
+}
+
+func createCA(fs afero.Fs, certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{Organization: []string{"agbridge"}, CommonName: "agbridge local CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+
+	if err := writeKeyPair(fs, certFile, keyFile, der, key); err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+
+	return cert, key, nil
+}
+
+func createLeaf(fs afero.Fs, certFile, keyFile string, ca *x509.Certificate, caKey crypto.Signer, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate certificate key: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{Organization: []string{"agbridge"}, CommonName: hosts[0]},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	return writeKeyPair(fs, certFile, keyFile, der, key)
+}
+
+func leafCovers(leaf, ca *x509.Certificate, hosts []string) bool {
+	if time.Until(leaf.NotAfter) < renewBefore {
+		return false
+	}
+
+	if !bytes.Equal(leaf.RawIssuer, ca.RawSubject) || leaf.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			if !slices.ContainsFunc(leaf.IPAddresses, ip.Equal) {
+				return false
+			}
+		} else if !slices.Contains(leaf.DNSNames, host) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func loadKeyPair(fs afero.Fs, certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := afero.ReadFile(fs, certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err := afero.ReadFile(fs, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("no PEM data found in %s", certFile)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", certFile, err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("no PEM data found in %s", keyFile)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", keyFile, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported key type in %s", keyFile)
+	}
+
+	return cert, signer, nil
+}
+
+func writeKeyPair(fs afero.Fs, certFile, keyFile string, der []byte, key crypto.Signer) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %w", err)
+	}
+
+	if err := afero.WriteFile(fs, keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", keyFile, err)
+	}
+
+	if err := afero.WriteFile(fs, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", certFile, err)
+	}
+
+	return nil
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
@@ -0,0 +1,79 @@
+package certs
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfSigned(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+
+	files, created, err := SelfSigned(fs, "tls", DefaultHostnames)
+	require.NoError(t, err)
+	assert.True(t, created, "expected the CA to be created")
+
+	caPEM, err := afero.ReadFile(fs, files.CACert)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(caPEM))
+
+	leaf, _, err := loadKeyPair(fs, files.Cert, files.Key)
+	require.NoError(t, err)
+	for _, host := range DefaultHostnames {
+		_, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: pool})
+		require.NoError(t, err, host)
+	}
+
+	tlsConfig, err := ServerConfig(fs, files.Cert, files.Key, files.CACert)
+	require.NoError(t, err)
+	assert.Len(t, tlsConfig.Certificates, 1)
+	assert.NotNil(t, tlsConfig.ClientCAs)
+
+	_, created, err = SelfSigned(fs, "tls", DefaultHostnames)
+	require.NoError(t, err)
+	assert.False(t, created, "expected the CA to be reused")
+
+	reused, _, err := loadKeyPair(fs, files.Cert, files.Key)
+	require.NoError(t, err)
+	assert.Equal(t, leaf.SerialNumber, reused.SerialNumber, "expected the certificate to be reused")
+
+	_, _, err = SelfSigned(fs, "tls", []string{"localhost", "agbridge.local"})
+	require.NoError(t, err)
+
+	reissued, _, err := loadKeyPair(fs, files.Cert, files.Key)
+	require.NoError(t, err)
+	assert.NotEqual(t, leaf.SerialNumber, reissued.SerialNumber, "expected a new certificate for new hostnames")
+	assert.Contains(t, reissued.DNSNames, "agbridge.local")
+
+	_, _, err = SelfSigned(fs, "tls", []string{""})
+	require.EqualError(t, err, "self-signed certificates require non-empty hostnames")
+}
+
+func TestTrustInstructions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		listenAddress string
+		hostname      string
+		exp           string
+	}{
+		{name: "Port", listenAddress: ":9443", hostname: "agbridge.local", exp: "curl --cacert ca.pem https://agbridge.local:9443/"},
+		{name: "Default port", listenAddress: "0.0.0.0:443", hostname: "localhost", exp: "curl --cacert ca.pem https://localhost/"},
+		{name: "IPv6", listenAddress: "[::1]:8443", hostname: "::1", exp: "curl --cacert ca.pem https://[::1]:8443/"},
+		{name: "Unix socket", listenAddress: "unix:///tmp/agbridge.sock", hostname: "localhost", exp: "curl --cacert ca.pem --unix-socket /tmp/agbridge.sock https://localhost/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Contains(t, TrustInstructions("ca.pem", tt.listenAddress, tt.hostname), "  curl:   "+tt.exp+"\n")
+		})
+	}
+}
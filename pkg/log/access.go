@@ -0,0 +1,102 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	AccessFormatCommon AccessFormat = "clf"
+	AccessFormatJSON   AccessFormat = "json"
+)
+
+const commonLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+type AccessFormat string
+
+type AccessEntry struct {
+	Time       time.Time
+	RemoteAddr string
+	Method     string
+	URI        string
+	Proto      string
+	Status     int
+	Size       int64
+	Duration   time.Duration
+	UserAgent  string
+	RequestID  string
+}
+
+type accessRecord struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Size       int64     `json:"size"`
+	ElapsedMS  int64     `json:"elapsed_ms"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+type accessLogger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format AccessFormat
+}
+
+var access *accessLogger
+
+// SetupAccess enables the access log stream, one line per request written to
+// out in the given format.
+func SetupAccess(format AccessFormat, out io.Writer) {
+	access = &accessLogger{out: out, format: format}
+}
+
+// Access writes entry to the access log stream, if enabled, with the
+// sensitive query parameters redacted.
+func Access(entry AccessEntry) {
+	if access == nil {
+		return
+	}
+	entry.URI = RedactURI(entry.URI)
+
+	access.mu.Lock()
+	defer access.mu.Unlock()
+
+	var err error
+	if access.format == AccessFormatJSON {
+		err = json.NewEncoder(access.out).Encode(accessRecord{
+			Time:       entry.Time,
+			RemoteAddr: entry.RemoteAddr,
+			Method:     entry.Method,
+			URI:        entry.URI,
+			Proto:      entry.Proto,
+			Status:     entry.Status,
+			Size:       entry.Size,
+			ElapsedMS:  entry.Duration.Milliseconds(),
+			UserAgent:  entry.UserAgent,
+			RequestID:  entry.RequestID,
+		})
+	} else {
+		host, _, splitErr := net.SplitHostPort(entry.RemoteAddr)
+		if splitErr != nil {
+			host = entry.RemoteAddr
+		}
+		_, err = fmt.Fprintf(access.out, "%s - - [%s] %q %d %d\n",
+			host,
+			entry.Time.Format(commonLogTimeFormat),
+			entry.Method+" "+entry.URI+" "+entry.Proto,
+			entry.Status,
+			entry.Size,
+		)
+	}
+	if err != nil {
+		Error("Failed to write access log", Err(err))
+	}
+}
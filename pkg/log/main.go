@@ -12,13 +12,22 @@ const (
 	LevelFatal = slog.Level(12)
 )
 
+const (
+	FormatConsole Format = "console"
+	FormatJSON    Format = "json"
+	FormatLogfmt  Format = "logfmt"
+)
+
 var (
 	With = slog.With
 
 	Info  = slog.Info
 	Debug = slog.Debug
+	Warn  = slog.Warn
+	Error = slog.Error
 
 	Any      = slog.Any
+	Bool     = slog.Bool
 	Duration = slog.Duration
 	Int      = slog.Int
 	String   = slog.String
@@ -26,4 +35,6 @@ var (
 
 type Level = slog.Level
 
+type Format string
+
 type Logger = slog.Logger
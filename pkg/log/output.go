@@ -0,0 +1,28 @@
+package log
+
+import (
+	"io"
+	"os"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const maxBackups = 5
+
+// Output returns the destination for log records: stderr when filename is
+// empty, stdout when it is "-", otherwise the file, rotated once it grows
+// beyond maxSizeMB megabytes.
+func Output(filename string, maxSizeMB int) io.Writer {
+	switch filename {
+	case "":
+		return os.Stderr
+	case "-":
+		return os.Stdout
+	default:
+		return &lumberjack.Logger{
+			Filename:   filename,
+			MaxSize:    maxSizeMB,
+			MaxBackups: maxBackups,
+		}
+	}
+}
@@ -20,3 +20,21 @@ func ParseLogLevel(levelStr string) (Level, error) {
 		return LevelInfo, errors.New("invalid log level: must be one of debug, info, warn, error, fatal")
 	}
 }
+
+func ParseFormat(formatStr string) (Format, error) {
+	switch Format(formatStr) {
+	case FormatConsole, FormatJSON, FormatLogfmt:
+		return Format(formatStr), nil
+	default:
+		return FormatConsole, errors.New("invalid log format: must be one of console, json, logfmt")
+	}
+}
+
+func ParseAccessFormat(formatStr string) (AccessFormat, error) {
+	switch AccessFormat(formatStr) {
+	case AccessFormatCommon, AccessFormatJSON:
+		return AccessFormat(formatStr), nil
+	default:
+		return AccessFormatCommon, errors.New("invalid access log format: must be one of clf, json")
+	}
+}
@@ -0,0 +1,441 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces sensitive values in log records.
+const Redacted = "[REDACTED]"
+
+const DefaultMaxBodySize = 4096
+
+// recursiveDescent marks a `..` step in a body field path.
+const recursiveDescent = ".."
+
+var defaultRedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Amz-Security-Token",
+}
+
+var defaultRedactedQueryParams = []string{
+	"access_token",
+	"api_key",
+	"apikey",
+	"code",
+	"id_token",
+	"key",
+	"password",
+	"secret",
+	"signature",
+	"token",
+	"X-Amz-Credential",
+	"X-Amz-Security-Token",
+	"X-Amz-Signature",
+}
+
+type RedactionConfig struct {
+	// Headers are redacted in addition to the default ones.
+	Headers []string `yaml:"headers" json:"headers,omitempty"`
+	// QueryParams are redacted from the logged URLs in addition to the
+	// default ones, ignoring case.
+	QueryParams []string `yaml:"query_params" json:"query_params,omitempty"`
+	// BodyFields are JSONPath-style expressions like `$.password`,
+	// `$.items[*].token` or `$..secret` masked in JSON bodies.
+	BodyFields []string `yaml:"body_fields" json:"body_fields,omitempty"`
+	// StageVariables are the names of stage variables to redact.
+	StageVariables []string `yaml:"stage_variables" json:"stage_variables,omitempty"`
+	// MaxBodySize truncates bodies longer than this number of bytes. Zero
+	// applies DefaultMaxBodySize and a negative value disables truncation.
+	MaxBodySize int `yaml:"max_body_size" json:"max_body_size,omitempty"`
+	// MaxLogSize truncates execution logs longer than this number of bytes,
+	// they are kept whole when zero.
+	MaxLogSize int `yaml:"max_log_size" json:"max_log_size,omitempty"`
+}
+
+type Redactor struct {
+	headers        map[string]struct{}
+	queryParams    map[string]struct{}
+	bodyFields     [][]string
+	stageVariables map[string]struct{}
+	maxBodySize    int
+	maxLogSize     int
+}
+
+var (
+	redactorMu sync.RWMutex
+	redactor   = &Redactor{
+		headers:     canonicalHeaderSet(nil),
+		queryParams: queryParamSet(nil),
+		maxBodySize: DefaultMaxBodySize,
+	}
+)
+
+func NewRedactor(cfg RedactionConfig) (*Redactor, error) {
+	r := &Redactor{
+		headers:        canonicalHeaderSet(cfg.Headers),
+		queryParams:    queryParamSet(cfg.QueryParams),
+		stageVariables: make(map[string]struct{}, len(cfg.StageVariables)),
+		maxBodySize:    cfg.MaxBodySize,
+		maxLogSize:     cfg.MaxLogSize,
+	}
+
+	if r.maxBodySize == 0 {
+		r.maxBodySize = DefaultMaxBodySize
+	}
+
+	for _, name := range cfg.StageVariables {
+		r.stageVariables[name] = struct{}{}
+	}
+
+	for _, field := range cfg.BodyFields {
+		path, err := parseFieldPath(field)
+		if err != nil {
+			return nil, err
+		}
+		r.bodyFields = append(r.bodyFields, path)
+	}
+
+	return r, nil
+}
+
+// SetRedactor replaces the redactor used by RedactHeaders, RedactURI,
+// RedactBody, RedactStageVariables and RedactText.
+func SetRedactor(r *Redactor) {
+	redactorMu.Lock()
+	defer redactorMu.Unlock()
+	redactor = r
+}
+
+func currentRedactor() *Redactor {
+	redactorMu.RLock()
+	defer redactorMu.RUnlock()
+	return redactor
+}
+
+func RedactHeaders(h http.Header) http.Header {
+	return currentRedactor().Headers(h)
+}
+
+func RedactURI(uri string) string {
+	return currentRedactor().URI(uri)
+}
+
+func RedactBody(body []byte) string {
+	return currentRedactor().Body(body)
+}
+
+func RedactStageVariables(vars map[string]string) map[string]string {
+	return currentRedactor().StageVariables(vars)
+}
+
+func RedactText(text string, vars map[string]string, headers []http.Header, bodies ...[]byte) string {
+	return currentRedactor().Text(text, vars, headers, bodies...)
+}
+
+// Headers returns a copy of h with the values of sensitive headers redacted.
+func (r *Redactor) Headers(h http.Header) http.Header {
+	redacted := make(http.Header, len(h))
+	for key, values := range h {
+		if _, ok := r.headers[http.CanonicalHeaderKey(key)]; ok {
+			redacted[key] = []string{Redacted}
+			continue
+		}
+		redacted[key] = values
+	}
+	return redacted
+}
+
+// URI returns uri with the values of sensitive query parameters redacted,
+// keeping the rest of the query as sent.
+func (r *Redactor) URI(uri string) string {
+	path, query, ok := strings.Cut(uri, "?")
+	if !ok {
+		return uri
+	}
+
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if _, ok := r.queryParams[strings.ToLower(key)]; ok {
+			params[i] = key + "=" + Redacted
+		}
+	}
+	return path + "?" + strings.Join(params, "&")
+}
+
+// StageVariables returns a copy of vars with the sensitive values redacted.
+func (r *Redactor) StageVariables(vars map[string]string) map[string]string {
+	if vars == nil {
+		return nil
+	}
+
+	redacted := make(map[string]string, len(vars))
+	for key, value := range vars {
+		if _, ok := r.stageVariables[key]; ok {
+			value = Redacted
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// Body masks the configured fields of a JSON body and truncates it. Bodies
+// that are not JSON are only truncated.
+func (r *Redactor) Body(body []byte) string {
+	if len(r.bodyFields) > 0 && json.Valid(body) {
+		if masked, err := r.maskJSON(body); err == nil {
+			body = masked
+		}
+	}
+
+	return r.truncate(string(body))
+}
+
+// Text redacts free-form text, like the execution log returned by API
+// Gateway, by replacing every value of the sensitive stage variables in vars,
+// of the sensitive headers in headers and of the body fields in the JSON
+// bodies. It truncates the result when a maximum log size is set.
+func (r *Redactor) Text(text string, vars map[string]string, headers []http.Header, bodies ...[]byte) string {
+	var secrets []string
+	for key, value := range vars {
+		if _, ok := r.stageVariables[key]; ok {
+			secrets = append(secrets, value)
+		}
+	}
+	for _, h := range headers {
+		for key, values := range h {
+			if _, ok := r.headers[http.CanonicalHeaderKey(key)]; ok {
+				secrets = append(secrets, values...)
+			}
+		}
+	}
+	for _, body := range bodies {
+		secrets = append(secrets, r.bodySecrets(body)...)
+	}
+
+	// Replace the longest values first, so values containing others are
+	// replaced whole
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	for _, secret := range secrets {
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, Redacted)
+		}
+	}
+
+	if r.maxLogSize > 0 {
+		return truncate(text, r.maxLogSize)
+	}
+	return text
+}
+
+// bodySecrets returns the values of the body fields in a JSON body.
+func (r *Redactor) bodySecrets(body []byte) []string {
+	if len(r.bodyFields) == 0 || !json.Valid(body) {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil
+	}
+
+	var secrets []string
+	for _, path := range r.bodyFields {
+		collectValues(value, path, &secrets)
+	}
+	return secrets
+}
+
+func (r *Redactor) truncate(s string) string {
+	if r.maxBodySize < 0 {
+		return s
+	}
+	return truncate(s, r.maxBodySize)
+}
+
+func truncate(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+	return fmt.Sprintf("%s...(truncated %d bytes)", s[:size], len(s)-size)
+}
+
+func (r *Redactor) maskJSON(body []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	for _, path := range r.bodyFields {
+		value = maskValue(value, path)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func maskValue(value any, path []string) any {
+	if len(path) == 0 {
+		return Redacted
+	}
+
+	step, rest := path[0], path[1:]
+
+	if step == recursiveDescent {
+		value = maskValue(value, rest)
+		rest = path
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if step == recursiveDescent || step == "*" || step == key {
+				v[key] = maskValue(child, rest)
+			}
+		}
+	case []any:
+		for i, child := range v {
+			if step == recursiveDescent || step == "*" || step == strconv.Itoa(i) {
+				v[i] = maskValue(child, rest)
+			}
+		}
+	}
+
+	return value
+}
+
+// collectValues appends the values found at path, like maskValue masks
+// them. Objects and arrays add their scalar values.
+func collectValues(value any, path []string, values *[]string) {
+	if len(path) == 0 {
+		collectScalars(value, values)
+		return
+	}
+
+	step, rest := path[0], path[1:]
+
+	if step == recursiveDescent {
+		collectValues(value, rest, values)
+		rest = path
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if step == recursiveDescent || step == "*" || step == key {
+				collectValues(child, rest, values)
+			}
+		}
+	case []any:
+		for i, child := range v {
+			if step == recursiveDescent || step == "*" || step == strconv.Itoa(i) {
+				collectValues(child, rest, values)
+			}
+		}
+	}
+}
+
+func collectScalars(value any, values *[]string) {
+	switch v := value.(type) {
+	case map[string]any:
+		for _, child := range v {
+			collectScalars(child, values)
+		}
+	case []any:
+		for _, child := range v {
+			collectScalars(child, values)
+		}
+	case string:
+		*values = append(*values, v)
+	case json.Number:
+		*values = append(*values, v.String())
+	}
+}
+
+// parseFieldPath splits a JSONPath-style expression like `$.a[*].b` or
+// `$..b` into its steps.
+func parseFieldPath(field string) ([]string, error) {
+	expr, ok := strings.CutPrefix(field, "$")
+	if !ok {
+		return nil, fmt.Errorf("invalid body field %q: must start with $", field)
+	}
+
+	var path []string
+	for expr != "" {
+		switch {
+		case strings.HasPrefix(expr, ".."):
+			path = append(path, recursiveDescent)
+			expr = expr[2:]
+		case strings.HasPrefix(expr, "."):
+			expr = expr[1:]
+		case strings.HasPrefix(expr, "["):
+			end := strings.IndexByte(expr, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid body field %q: unclosed [", field)
+			}
+			path = append(path, strings.Trim(expr[1:end], `'"`))
+			expr = expr[end+1:]
+			continue
+		default:
+			return nil, fmt.Errorf("invalid body field %q: unexpected %q", field, expr)
+		}
+
+		end := strings.IndexAny(expr, ".[")
+		if end < 0 {
+			end = len(expr)
+		}
+		if end == 0 {
+			continue
+		}
+		path = append(path, expr[:end])
+		expr = expr[end:]
+	}
+
+	if len(path) == 0 || path[len(path)-1] == recursiveDescent {
+		return nil, fmt.Errorf("invalid body field %q: missing field name", field)
+	}
+
+	return path, nil
+}
+
+func queryParamSet(extra []string) map[string]struct{} {
+	params := make(map[string]struct{}, len(defaultRedactedQueryParams)+len(extra))
+	for _, name := range append(append([]string(nil), defaultRedactedQueryParams...), extra...) {
+		params[strings.ToLower(name)] = struct{}{}
+	}
+	return params
+}
+
+func canonicalHeaderSet(extra []string) map[string]struct{} {
+	headers := make(map[string]struct{}, len(defaultRedactedHeaders)+len(extra))
+	for _, name := range append(append([]string(nil), defaultRedactedHeaders...), extra...) {
+		headers[http.CanonicalHeaderKey(name)] = struct{}{}
+	}
+	return headers
+}
@@ -0,0 +1,165 @@
+package log
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactorHeaders(t *testing.T) {
+	t.Parallel()
+
+	r, err := NewRedactor(RedactionConfig{Headers: []string{"x-secret"}})
+	require.NoError(t, err)
+
+	headers := http.Header{
+		"Authorization": {"Bearer token"},
+		"Cookie":        {"session=abc"},
+		"X-Api-Key":     {"key"},
+		"X-Secret":      {"value"},
+		"Accept":        {"application/json"},
+	}
+
+	assert.Equal(t, http.Header{
+		"Authorization": {Redacted},
+		"Cookie":        {Redacted},
+		"X-Api-Key":     {Redacted},
+		"X-Secret":      {Redacted},
+		"Accept":        {"application/json"},
+	}, r.Headers(headers))
+	assert.Equal(t, []string{"Bearer token"}, headers["Authorization"], "input headers must not be modified")
+}
+
+func TestRedactorURI(t *testing.T) {
+	t.Parallel()
+
+	r, err := NewRedactor(RedactionConfig{QueryParams: []string{"session"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, "/dev/users", r.URI("/dev/users"))
+	assert.Equal(t, "/dev/users?page=2&Token=[REDACTED]&session=[REDACTED]&flag",
+		r.URI("/dev/users?page=2&Token=s3cr3t&session=abc&flag"))
+	assert.Equal(t, "/files?X-Amz-Signature=[REDACTED]&api_key=[REDACTED]",
+		r.URI("/files?X-Amz-Signature=abc123&api%5Fkey=xyz"))
+}
+
+func TestRedactorBody(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		fields      []string
+		maxBodySize int
+		body        string
+		expBody     string
+	}{
+		{
+			name:    "Top level field",
+			fields:  []string{"$.password"},
+			body:    `{"user":"bob","password":"hunter2"}`,
+			expBody: `{"password":"[REDACTED]","user":"bob"}`,
+		},
+		{
+			name:    "Nested field in array",
+			fields:  []string{"$.items[*].token"},
+			body:    `{"items":[{"id":1,"token":"a"},{"id":2,"token":"b"}]}`,
+			expBody: `{"items":[{"id":1,"token":"[REDACTED]"},{"id":2,"token":"[REDACTED]"}]}`,
+		},
+		{
+			name:    "Recursive descent",
+			fields:  []string{"$..secret"},
+			body:    `{"secret":"a","nested":{"secret":"b","list":[{"secret":"c"}]}}`,
+			expBody: `{"nested":{"list":[{"secret":"[REDACTED]"}],"secret":"[REDACTED]"},"secret":"[REDACTED]"}`,
+		},
+		{
+			name:    "Array index",
+			fields:  []string{"$[1]"},
+			body:    `["a","b","c"]`,
+			expBody: `["a","[REDACTED]","c"]`,
+		},
+		{
+			name:    "Missing field",
+			fields:  []string{"$.password"},
+			body:    `{"user":"bob","amount":1.50}`,
+			expBody: `{"amount":1.50,"user":"bob"}`,
+		},
+		{
+			name:    "Not JSON",
+			fields:  []string{"$.password"},
+			body:    `password=hunter2`,
+			expBody: `password=hunter2`,
+		},
+		{
+			name:        "Truncated",
+			maxBodySize: 5,
+			body:        `0123456789`,
+			expBody:     `01234...(truncated 5 bytes)`,
+		},
+		{
+			name:        "Truncation disabled",
+			maxBodySize: -1,
+			body:        strings.Repeat("a", DefaultMaxBodySize+1),
+			expBody:     strings.Repeat("a", DefaultMaxBodySize+1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r, err := NewRedactor(RedactionConfig{BodyFields: tt.fields, MaxBodySize: tt.maxBodySize})
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expBody, r.Body([]byte(tt.body)))
+		})
+	}
+}
+
+func TestRedactorText(t *testing.T) {
+	t.Parallel()
+
+	r, err := NewRedactor(RedactionConfig{StageVariables: []string{"dbPassword"}, BodyFields: []string{"$.password", "$..token"}})
+	require.NoError(t, err)
+
+	text := "Method request headers: {Authorization=Bearer token, Accept=*/*}\n" +
+		"Stage variables: {dbPassword=s3cr3t}\n" +
+		`Method request body before transformations: {"user":"jane","password":"hunter2"}` + "\n" +
+		`Endpoint response body before transformations: {"session":{"token":"abc123","ttl":3600}}` + "\n" +
+		"Method response headers: {Set-Cookie=session=abc123; Path=/, Content-Type=application/json}"
+	requestHeaders := http.Header{"Authorization": {"Bearer token"}, "Accept": {"*/*"}}
+	responseHeaders := http.Header{"Set-Cookie": {"session=abc123; Path=/"}, "Content-Type": {"application/json"}}
+	vars := map[string]string{"dbPassword": "s3cr3t", "env": "dev"}
+
+	assert.Equal(t,
+		"Method request headers: {Authorization=[REDACTED], Accept=*/*}\n"+
+			"Stage variables: {dbPassword=[REDACTED]}\n"+
+			`Method request body before transformations: {"user":"jane","password":"[REDACTED]"}`+"\n"+
+			`Endpoint response body before transformations: {"session":{"token":"[REDACTED]","ttl":3600}}`+"\n"+
+			"Method response headers: {Set-Cookie=[REDACTED], Content-Type=application/json}",
+		r.Text(text, vars, []http.Header{requestHeaders, responseHeaders},
+			[]byte(`{"user":"jane","password":"hunter2"}`),
+			[]byte(`{"session":{"token":"abc123","ttl":3600}}`),
+		),
+	)
+	assert.Equal(t, map[string]string{"dbPassword": Redacted, "env": "dev"}, r.StageVariables(vars))
+
+	// Execution logs are only truncated when asked
+	long := strings.Repeat("a", 2*DefaultMaxBodySize)
+	assert.Equal(t, long, r.Text(long, nil, nil))
+
+	r, err = NewRedactor(RedactionConfig{MaxLogSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, "aaaaaaaaaa...(truncated 8182 bytes)", r.Text(long, nil, nil))
+}
+
+func TestNewRedactorInvalidBodyField(t *testing.T) {
+	t.Parallel()
+
+	for _, field := range []string{"password", "$.items[*", "$.."} {
+		_, err := NewRedactor(RedactionConfig{BodyFields: []string{field}})
+		require.Error(t, err, field)
+	}
+}
@@ -1,13 +1,31 @@
 package log
 
 import (
+	"io"
 	"log/slog"
 )
 
-func Setup(level Level) {
-	sink := newSlogZeroLogHandler(level)
+func Setup(level Level, format Format, out io.Writer) {
+	var sink slog.Handler
+	if format == FormatLogfmt {
+		sink = newSlogTextHandler(level, out)
+	} else {
+		sink = newSlogZeroLogHandler(level, format, out)
+	}
 
 	logger := slog.New(sink)
 
 	slog.SetDefault(logger)
 }
+
+func newSlogTextHandler(level slog.Level, out io.Writer) slog.Handler {
+	return slog.NewTextHandler(out, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && a.Value.Any() == LevelFatal {
+				return slog.String(slog.LevelKey, "FATAL")
+			}
+			return a
+		},
+	})
+}
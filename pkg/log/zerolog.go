@@ -1,6 +1,7 @@
 package log
 
 import (
+	"io"
 	"log/slog"
 	"os"
 	"time"
@@ -9,7 +10,7 @@ import (
 	slogzerolog "github.com/samber/slog-zerolog/v2"
 )
 
-func newSlogZeroLogHandler(level slog.Level) slog.Handler {
+func newSlogZeroLogHandler(level slog.Level, format Format, out io.Writer) slog.Handler {
 	slogzerolog.ErrorKeys = []string{errKey}
 	slogzerolog.LogLevels = map[slog.Level]zerolog.Level{
 		LevelDebug: zerolog.DebugLevel,
@@ -19,7 +20,11 @@ func newSlogZeroLogHandler(level slog.Level) slog.Handler {
 		LevelFatal: zerolog.FatalLevel,
 	}
 
-	zerologLogger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.TimeOnly})
+	if format != FormatJSON {
+		out = zerolog.ConsoleWriter{Out: out, TimeFormat: time.TimeOnly, NoColor: out != os.Stderr}
+	}
+
+	zerologLogger := zerolog.New(out)
 
 	return slogzerolog.Option{Level: level, Logger: &zerologLogger}.NewZerologHandler()
 }
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseRecorder(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		handle    func(w http.ResponseWriter)
+		expStatus int
+		expSize   int64
+	}{
+		{
+			name:      "Nothing written",
+			handle:    func(w http.ResponseWriter) {},
+			expStatus: http.StatusOK,
+		},
+		{
+			name:      "Body without status",
+			handle:    func(w http.ResponseWriter) { _, _ = w.Write([]byte("hello")) },
+			expStatus: http.StatusOK,
+			expSize:   5,
+		},
+		{
+			name:      "Status without body",
+			handle:    func(w http.ResponseWriter) { w.WriteHeader(http.StatusNoContent) },
+			expStatus: http.StatusNoContent,
+		},
+		{
+			name: "Status written twice",
+			handle: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusCreated)
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte("created"))
+			},
+			expStatus: http.StatusCreated,
+			expSize:   7,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			rec := &responseRecorder{ResponseWriter: w}
+			tt.handle(rec)
+
+			assert.Equal(t, tt.expStatus, rec.statusCode())
+			assert.Equal(t, tt.expSize, rec.size)
+			assert.Equal(t, tt.expStatus, w.Code)
+		})
+	}
+}
+
+func TestWithAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	log.SetupAccess(log.AccessFormatJSON, &out)
+	t.Cleanup(func() { log.SetupAccess(log.AccessFormatJSON, io.Discard) })
+
+	handler := withAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/access-log/body" {
+			_, _ = w.Write([]byte("hello"))
+		}
+	}))
+
+	for _, path := range []string{"/access-log/empty", "/access-log/body"} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set(RequestIDHeader, "req-"+strings.TrimPrefix(path, "/access-log/"))
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		if strings.HasPrefix(entry["uri"].(string), "/access-log/") {
+			entries = append(entries, entry)
+		}
+	}
+
+	require.Len(t, entries, 2)
+	assert.InDelta(t, http.StatusOK, entries[0]["status"], 0)
+	assert.InDelta(t, 0, entries[0]["size"], 0)
+	assert.Equal(t, "req-empty", entries[0]["request_id"])
+	assert.InDelta(t, http.StatusOK, entries[1]["status"], 0)
+	assert.InDelta(t, 5, entries[1]["size"], 0)
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/samber/lo"
+)
+
+// AdminPathPrefix is reserved for the introspection endpoints and is never
+// forwarded to API Gateway.
+const AdminPathPrefix = "/_agbridge"
+
+type gatewaysResponse struct {
+	Status   string          `json:"status"`
+	Gateways []GatewayStatus `json:"gateways"`
+}
+
+func (p *Proxy) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /routes", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, loadedRoutes(p.HandlerMapping()))
+	})
+
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		gateways := p.Gateways()
+		healthy := lo.EveryBy(gateways, func(gw GatewayStatus) bool { return gw.Error == "" })
+		writeGatewayStatus(w, healthy, gateways)
+	})
+
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		gateways := p.Gateways()
+		ready := lo.EveryBy(gateways, func(gw GatewayStatus) bool { return gw.Ready })
+		writeGatewayStatus(w, ready, gateways)
+	})
+
+	mux.HandleFunc("GET /config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, p.config.Redacted())
+	})
+
+	mux.Handle("GET /metrics", metricsHandler())
+
+	mux.HandleFunc("GET /faults", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, p.faults.status())
+	})
+
+	mux.HandleFunc("POST /faults/{name}/{action}", func(w http.ResponseWriter, r *http.Request) {
+		action := r.PathValue("action")
+		if action != "enable" && action != "disable" {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown action %s", action))
+			return
+		}
+
+		name := r.PathValue("name")
+		if !p.faults.setEnabled(name, action == "enable") {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown fault rule %s", name))
+			return
+		}
+
+		log.FromContext(r.Context()).Info("Toggled fault rule", log.String("fault", name), log.Bool("enabled", action == "enable"))
+		writeJSON(w, http.StatusOK, p.faults.status())
+	})
+
+	mux.HandleFunc("POST /cache/purge", func(w http.ResponseWriter, r *http.Request) {
+		prefix := r.URL.Query().Get("path")
+		purged := p.cache.purge(prefix)
+		log.FromContext(r.Context()).Info("Purged response cache", log.String("path", prefix), log.Int("purged", purged))
+		writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+	})
+
+	mux.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		logger := log.FromContext(r.Context())
+		logger.Info("Reloading routes")
+		if err := p.Reload(); err != nil {
+			logger.Error("Failed to reload routes", log.Err(err))
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeGatewayStatus(w, true, p.Gateways())
+	})
+
+	return mux
+}
+
+func writeGatewayStatus(w http.ResponseWriter, ok bool, gateways []GatewayStatus) {
+	if !ok {
+		writeJSON(w, http.StatusServiceUnavailable, gatewaysResponse{Status: "unavailable", Gateways: gateways})
+		return
+	}
+	writeJSON(w, http.StatusOK, gatewaysResponse{Status: "ok", Gateways: gateways})
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Failed to encode admin response", log.Err(err))
+	}
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oscarbc96/agbridge/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAdminTestProxy returns a proxy whose gateways resolve with the handlers
+// of resolved, or fail with the errors of failed.
+func newAdminTestProxy(t *testing.T, config *Config, resolved map[string][]Handler, failed map[string]error) *Proxy {
+	t.Helper()
+
+	p := NewProxy(nil, config)
+	p.resolve = func(gw GatewayConfig) ([]Handler, error) {
+		if err, ok := failed[gw.RestAPIID]; ok {
+			return nil, err
+		}
+		return resolved[gw.RestAPIID], nil
+	}
+	return p
+}
+
+func serveAdmin(t *testing.T, p *Proxy, method, target string) (int, map[string]any) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	p.adminHandler().ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var body map[string]any
+	if rec.Body.Len() > 0 && rec.Body.Bytes()[0] == '{' {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	}
+	return rec.Code, body
+}
+
+func TestAdminRoutes(t *testing.T) {
+	t.Parallel()
+
+	p := newAdminTestProxy(t, &Config{
+		Gateways: []GatewayConfig{{RestAPIID: "abc123"}},
+		Mocks:    []MockConfig{{Path: "/health", Body: "ok"}},
+	}, map[string][]Handler{
+		// The identity is recorded when the routes load, listing them never
+		// calls STS
+		"abc123": {{StagePath: "/users", Path: "/users", RestAPIID: "abc123", Methods: []string{"GET"}, AccountID: "123456789012", Identity: "arn:aws:iam::123456789012:user/dev"}},
+	}, nil)
+	require.NoError(t, p.Reload())
+
+	rec := httptest.NewRecorder()
+	p.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/routes", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var routes []Route
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &routes))
+	assert.Equal(t, []Route{
+		{Path: "/health", Methods: []string{anyMethod}, Mock: true},
+		{Path: "/users", Methods: []string{"GET"}, RestAPIID: "abc123", AccountID: "123456789012", Identity: "arn:aws:iam::123456789012:user/dev"},
+	}, routes)
+}
+
+func TestAdminGatewayStatus(t *testing.T) {
+	t.Parallel()
+
+	config := &Config{Gateways: []GatewayConfig{{RestAPIID: "abc123"}, {RestAPIID: "def456", StageName: "prod"}}}
+	handlers := map[string][]Handler{
+		"abc123": {{StagePath: "/users", Path: "/users", RestAPIID: "abc123", Methods: []string{"GET"}}},
+	}
+	p := newAdminTestProxy(t, config, handlers, map[string]error{"def456": errors.New("access denied")})
+
+	// Gateways still loading are healthy but not ready
+	status, body := serveAdmin(t, p, http.MethodGet, "/healthz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ok", body["status"])
+	status, body = serveAdmin(t, p, http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "unavailable", body["status"])
+
+	// A failing gateway is neither healthy nor ready
+	status, body = serveAdmin(t, p, http.MethodPost, "/reload")
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, "access denied", body["error"])
+
+	status, body = serveAdmin(t, p, http.MethodGet, "/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	gateways := body["gateways"].([]any)
+	require.Len(t, gateways, 2)
+	assert.Equal(t, true, gateways[0].(map[string]any)["ready"])
+	assert.InDelta(t, 1, gateways[0].(map[string]any)["routes"], 0)
+	assert.Equal(t, "access denied", gateways[1].(map[string]any)["error"])
+	status, _ = serveAdmin(t, p, http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+
+	// Reloading once the gateway recovers makes it ready
+	p.resolve = func(gw GatewayConfig) ([]Handler, error) { return handlers[gw.RestAPIID], nil }
+	status, body = serveAdmin(t, p, http.MethodPost, "/reload")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ok", body["status"])
+
+	status, _ = serveAdmin(t, p, http.MethodGet, "/healthz")
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = serveAdmin(t, p, http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestAdminConfig(t *testing.T) {
+	t.Parallel()
+
+	config := &Config{Gateways: []GatewayConfig{{RestAPIID: "abc123", StageName: "prod"}}}
+	config.Auth.Tokens = []auth.TokenConfig{{Name: "ci", Token: "secret-token"}}
+	p := NewProxy(nil, config)
+
+	rec := httptest.NewRecorder()
+	p.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"rest_api_id":"abc123"`)
+	assert.NotContains(t, rec.Body.String(), "secret-token")
+}
+
+func TestAdminFaults(t *testing.T) {
+	t.Parallel()
+
+	p := NewProxy(nil, &Config{Faults: []FaultRule{{Name: "slow", Match: FaultMatch{Path: "/users"}, StatusCodes: []int{503}}}})
+
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		expStatus int
+		expError  string
+		expFaults []FaultStatus
+	}{
+		{name: "List", method: http.MethodGet, target: "/faults", expStatus: http.StatusOK, expFaults: []FaultStatus{{Name: "slow", Enabled: true}}},
+		{name: "Disable", method: http.MethodPost, target: "/faults/slow/disable", expStatus: http.StatusOK, expFaults: []FaultStatus{{Name: "slow", Enabled: false}}},
+		{name: "Enable", method: http.MethodPost, target: "/faults/slow/enable", expStatus: http.StatusOK, expFaults: []FaultStatus{{Name: "slow", Enabled: true}}},
+		{name: "Unknown rule", method: http.MethodPost, target: "/faults/fast/enable", expStatus: http.StatusNotFound, expError: "unknown fault rule fast"},
+		{name: "Unknown action", method: http.MethodPost, target: "/faults/slow/toggle", expStatus: http.StatusNotFound, expError: "unknown action toggle"},
+	}
+
+	// The cases share the fault injector and run in order
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			p.adminHandler().ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			require.Equal(t, tt.expStatus, rec.Code)
+
+			if tt.expError != "" {
+				assert.JSONEq(t, `{"error": "`+tt.expError+`"}`, rec.Body.String())
+				return
+			}
+			var faults []FaultStatus
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &faults))
+			assert.Equal(t, tt.expFaults, faults)
+		})
+	}
+}
+
+func TestAdminCachePurge(t *testing.T) {
+	t.Parallel()
+
+	p := NewProxy(nil, &Config{Cache: &CacheConfig{TTL: time.Minute, MaxEntries: 10}})
+	for _, path := range []string{"/dev/users", "/dev/users/1", "/dev/orders"} {
+		p.cache.set(&cachedResponse{key: path, path: path, status: http.StatusOK, headers: http.Header{}}, time.Minute)
+	}
+
+	status, body := serveAdmin(t, p, http.MethodPost, "/cache/purge?path=/dev/users")
+	assert.Equal(t, http.StatusOK, status)
+	assert.InDelta(t, 2, body["purged"], 0)
+
+	status, body = serveAdmin(t, p, http.MethodPost, "/cache/purge")
+	assert.Equal(t, http.StatusOK, status)
+	assert.InDelta(t, 1, body["purged"], 0)
+
+	// Without a response cache nothing is purged
+	status, body = serveAdmin(t, NewProxy(nil, &Config{}), http.MethodPost, "/cache/purge")
+	assert.Equal(t, http.StatusOK, status)
+	assert.InDelta(t, 0, body["purged"], 0)
+}
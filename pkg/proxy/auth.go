@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/oscarbc96/agbridge/pkg/auth"
+	"github.com/oscarbc96/agbridge/pkg/log"
+)
+
+// UseAuth requires callers to authenticate with the given authenticator.
+func (p *Proxy) UseAuth(authenticator *auth.Authenticator) {
+	p.authenticator = authenticator
+}
+
+// withAuth rejects the requests whose caller can't be authenticated. The
+// credentials issued by agbridge are not forwarded upstream.
+func (p *Proxy) withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.authenticator == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Browsers never send credentials in preflight requests, they are
+		// answered locally or rejected by the handler before reaching the API
+		if isPreflight(r) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), unauthenticatedPreflightKey{}, true)))
+			return
+		}
+
+		principal, err := p.authenticator.Authenticate(r)
+		if err != nil {
+			auditDenied(r, nil, "", err.Error())
+			w.Header().Set("WWW-Authenticate", p.authenticator.Challenge())
+			writeError(w, r, http.StatusUnauthorized, nil, "Unauthorized")
+			return
+		}
+
+		if principal.Method == auth.MethodToken || principal.Method == auth.MethodBasic {
+			r.Header.Del("Authorization")
+		}
+
+		ctx := auth.NewContext(r.Context(), principal)
+		logger := log.FromContext(ctx).With(log.String("principal", principal.Name))
+		next.ServeHTTP(w, r.WithContext(log.NewContext(ctx, logger)))
+	})
+}
+
+type unauthenticatedPreflightKey struct{}
+
+func isUnauthenticatedPreflight(r *http.Request) bool {
+	unauthenticated, _ := r.Context().Value(unauthenticatedPreflightKey{}).(bool)
+	return unauthenticated
+}
+
+// auditDenied records a request rejected by the inbound authentication.
+func auditDenied(r *http.Request, principal *auth.Principal, restAPIID, reason string) {
+	attrs := []any{
+		log.Bool("audit", true),
+		log.String("remote_addr", r.RemoteAddr),
+		log.String("method", r.Method),
+		log.String("path", r.URL.Path),
+		log.String("reason", reason),
+	}
+	if principal != nil {
+		attrs = append(attrs, log.String("principal", principal.Name), log.String("auth_method", principal.Method))
+	}
+	if restAPIID != "" {
+		attrs = append(attrs, log.String("rest_api_id", restAPIID))
+	}
+
+	log.FromContext(r.Context()).Warn("Denied request", attrs...)
+}
@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oscarbc96/agbridge/pkg/auth"
+	"github.com/samber/lo"
+)
+
+// DefaultCacheMaxEntries bounds the responses kept in memory when the
+// configuration doesn't.
+const DefaultCacheMaxEntries = 1000
+
+// CacheHeader tells whether a response was served from the cache.
+const CacheHeader = "X-Agbridge-Cache"
+
+// CacheRouteConfig overrides the TTL of the routes matching a path template.
+type CacheRouteConfig struct {
+	Path string        `yaml:"path" json:"path"`
+	TTL  time.Duration `yaml:"ttl" json:"ttl"`
+}
+
+// CacheConfig caches the GET and HEAD responses of API Gateway in memory.
+type CacheConfig struct {
+	// TTL applies to the routes without an override, disabled when zero.
+	TTL        time.Duration `yaml:"ttl" json:"ttl,omitempty"`
+	MaxEntries int           `yaml:"max_entries" json:"max_entries,omitempty"`
+	// KeyHeaders are the request headers responses vary on, besides the path
+	// and query.
+	KeyHeaders []string           `yaml:"key_headers" json:"key_headers,omitempty"`
+	Routes     []CacheRouteConfig `yaml:"routes" json:"routes,omitempty"`
+}
+
+func (c *CacheConfig) validate() error {
+	if c.TTL < 0 || c.MaxEntries < 0 {
+		return errors.New("cache ttl and max_entries can't be negative")
+	}
+	for _, route := range c.Routes {
+		if _, err := convertPathToRegex(route.Path); err != nil || !strings.HasPrefix(route.Path, "/") {
+			return fmt.Errorf("invalid cache route path %q", route.Path)
+		}
+		if route.TTL < 0 {
+			return fmt.Errorf("cache ttl of route %s can't be negative", route.Path)
+		}
+	}
+	return nil
+}
+
+type cachedResponse struct {
+	key     string
+	path    string
+	status  int
+	headers map[string][]string
+	body    string
+	stored  time.Time
+	expires time.Time
+}
+
+// responseCache is a LRU cache of API Gateway responses.
+type responseCache struct {
+	config   CacheConfig
+	patterns []*regexp.Regexp
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func newResponseCache(config *CacheConfig) *responseCache {
+	if config == nil {
+		return nil
+	}
+
+	c := &responseCache{
+		config:  *config,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	c.config.MaxEntries = lo.CoalesceOrEmpty(c.config.MaxEntries, DefaultCacheMaxEntries)
+
+	for _, route := range config.Routes {
+		// Paths are validated when loading the configuration
+		pattern, _ := convertPathToRegex(route.Path)
+		c.patterns = append(c.patterns, pattern)
+	}
+
+	return c
+}
+
+// key returns the cache key and TTL of the request, and false when its
+// response can't be cached.
+func (c *responseCache) key(r *http.Request, handler *Handler, identity string) (string, time.Duration, bool) {
+	if c == nil || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return "", 0, false
+	}
+
+	path := getPath(r.URL)
+	ttl := c.config.TTL
+	for i, pattern := range c.patterns {
+		if pattern.MatchString(path) {
+			ttl = c.config.Routes[i].TTL
+			break
+		}
+	}
+	if ttl <= 0 {
+		return "", 0, false
+	}
+
+	// Responses vary on the caller, authenticated by agbridge or by the
+	// Authorization header forwarded to API Gateway
+	var principal string
+	if p := auth.FromContext(r.Context()); p != nil {
+		principal = p.Method + ":" + p.Name
+	}
+	authorization := sha256.Sum256([]byte(strings.Join(r.Header.Values("Authorization"), "\n")))
+
+	var key strings.Builder
+	fmt.Fprintf(&key, "%s %s %s %s?%s %s %s %x", handler.RestAPIID, handler.ResourceID, r.Method, path, r.URL.Query().Encode(), identity, principal, authorization)
+	for _, header := range c.config.KeyHeaders {
+		fmt.Fprintf(&key, "\n%s: %s", http.CanonicalHeaderKey(header), strings.Join(r.Header.Values(header), ","))
+	}
+
+	return key.String(), ttl, true
+}
+
+// get returns the fresh response stored for the key, unless the request
+// asks to revalidate it.
+func (c *responseCache) get(r *http.Request, key string) (*cachedResponse, bool) {
+	if noCache(r.Header) {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cachedResponse)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return entry, true
+}
+
+// set stores the response for the TTL, or the lifetime set by its
+// Cache-Control header. Responses setting cookies are never stored, they
+// belong to a single caller.
+func (c *responseCache) set(entry *cachedResponse, ttl time.Duration) {
+	if !cacheableStatus(entry.status) || hasHeader(entry.headers, "Set-Cookie") {
+		return
+	}
+
+	ttl, ok := responseTTL(entry.headers, ttl)
+	if !ok {
+		return
+	}
+
+	entry.stored = time.Now()
+	entry.expires = entry.stored.Add(ttl)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[entry.key]; ok {
+		c.remove(elem)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.config.MaxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *responseCache) remove(elem *list.Element) {
+	delete(c.entries, elem.Value.(*cachedResponse).key)
+	c.lru.Remove(elem)
+}
+
+// purge removes the responses of the paths starting with prefix, every
+// response when empty, and returns how many were removed.
+func (c *responseCache) purge(prefix string) int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if strings.HasPrefix(elem.Value.(*cachedResponse).path, prefix) {
+			c.remove(elem)
+			purged++
+		}
+		elem = next
+	}
+	return purged
+}
+
+// hasHeader reports whether the headers of an API Gateway response, which
+// aren't canonicalized, have the header.
+func hasHeader(headers map[string][]string, name string) bool {
+	return lo.SomeBy(lo.Keys(headers), func(key string) bool { return http.CanonicalHeaderKey(key) == name })
+}
+
+func noCache(header http.Header) bool {
+	directives := cacheControl(header.Values("Cache-Control"))
+	return lo.HasKey(directives, "no-cache") || lo.HasKey(directives, "no-store")
+}
+
+func cacheableStatus(status int) bool {
+	return lo.Contains([]int{
+		http.StatusOK,
+		http.StatusNonAuthoritativeInfo,
+		http.StatusNoContent,
+		http.StatusMovedPermanently,
+		http.StatusNotFound,
+		http.StatusGone,
+	}, status)
+}
+
+// responseTTL returns the lifetime of a response, the TTL unless its
+// Cache-Control header sets one, and false when it must not be stored.
+func responseTTL(headers map[string][]string, ttl time.Duration) (time.Duration, bool) {
+	var values []string
+	for key, v := range headers {
+		if http.CanonicalHeaderKey(key) == "Cache-Control" {
+			values = append(values, v...)
+		}
+	}
+
+	directives := cacheControl(values)
+	if lo.HasKey(directives, "no-store") || lo.HasKey(directives, "no-cache") || lo.HasKey(directives, "private") {
+		return 0, false
+	}
+
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[directive]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	return ttl, true
+}
+
+// cacheControl parses Cache-Control header values into their directives.
+func cacheControl(values []string) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oscarbc96/agbridge/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCacheKey(t *testing.T) {
+	t.Parallel()
+
+	cache := newResponseCache(&CacheConfig{
+		TTL:        time.Minute,
+		KeyHeaders: []string{"Accept"},
+		Routes: []CacheRouteConfig{
+			{Path: "/dev/users/{id}", TTL: time.Hour},
+			{Path: "/dev/orders", TTL: 0},
+		},
+	})
+	handler := &Handler{RestAPIID: "api", ResourceID: "res"}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		expTTL time.Duration
+		expOk  bool
+	}{
+		{name: "Default TTL", method: http.MethodGet, target: "/dev/products?b=2&a=1", expTTL: time.Minute, expOk: true},
+		{name: "Route TTL", method: http.MethodHead, target: "/dev/users/42", expTTL: time.Hour, expOk: true},
+		{name: "Route without cache", method: http.MethodGet, target: "/dev/orders"},
+		{name: "Not idempotent", method: http.MethodPost, target: "/dev/products"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, ttl, ok := cache.key(httptest.NewRequest(tt.method, tt.target, nil), handler, "")
+			assert.Equal(t, tt.expOk, ok)
+			assert.Equal(t, tt.expTTL, ttl)
+		})
+	}
+
+	key := func(target, accept string) string {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Accept", accept)
+		key, _, _ := cache.key(r, handler, "")
+		return key
+	}
+	assert.Equal(t, key("/dev/products?b=2&a=1", "application/json"), key("/dev/products?a=1&b=2", "application/json"))
+	assert.NotEqual(t, key("/dev/products", "application/json"), key("/dev/products", "text/html"))
+
+	assert.Nil(t, newResponseCache(nil))
+}
+
+func TestResponseCacheKeyPerCaller(t *testing.T) {
+	t.Parallel()
+
+	cache := newResponseCache(&CacheConfig{TTL: time.Minute})
+	handler := &Handler{RestAPIID: "api", ResourceID: "res"}
+
+	key := func(principal *auth.Principal, authorization string) string {
+		r := httptest.NewRequest(http.MethodGet, "/dev/me", nil)
+		if principal != nil {
+			r = r.WithContext(auth.NewContext(r.Context(), principal))
+		}
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		key, _, ok := cache.key(r, handler, "")
+		require.True(t, ok)
+		return key
+	}
+
+	alice := key(&auth.Principal{Name: "alice", Method: auth.MethodJWT}, "")
+	bob := key(&auth.Principal{Name: "bob", Method: auth.MethodJWT}, "")
+	assert.NotEqual(t, alice, bob)
+	assert.NotEqual(t, key(nil, "Bearer a"), key(nil, "Bearer b"))
+	assert.NotContains(t, key(nil, "Bearer secret"), "secret")
+
+	// Two principals calling the same path get their own entries
+	cache.set(&cachedResponse{key: alice, path: "/dev/me", status: http.StatusOK, body: "alice"}, time.Minute)
+	cache.set(&cachedResponse{key: bob, path: "/dev/me", status: http.StatusOK, body: "bob"}, time.Minute)
+	r := httptest.NewRequest(http.MethodGet, "/dev/me", nil)
+	entry, ok := cache.get(r, alice)
+	require.True(t, ok)
+	assert.Equal(t, "alice", entry.body)
+	entry, ok = cache.get(r, bob)
+	require.True(t, ok)
+	assert.Equal(t, "bob", entry.body)
+
+	cache.set(&cachedResponse{key: "cookie", status: http.StatusOK, headers: map[string][]string{"set-cookie": {"session=1"}}}, time.Minute)
+	_, ok = cache.get(r, "cookie")
+	assert.False(t, ok, "responses setting cookies must not be stored")
+}
+
+func TestResponseCacheGetSet(t *testing.T) {
+	t.Parallel()
+
+	cache := newResponseCache(&CacheConfig{TTL: time.Minute, MaxEntries: 2})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	cache.set(&cachedResponse{key: "a", path: "/dev/a", status: http.StatusOK}, time.Minute)
+	cache.set(&cachedResponse{key: "b", path: "/dev/b", status: http.StatusOK}, time.Minute)
+	cache.set(&cachedResponse{key: "error", status: http.StatusInternalServerError}, time.Minute)
+	cache.set(&cachedResponse{key: "private", status: http.StatusOK, headers: map[string][]string{"cache-control": {"private"}}}, time.Minute)
+
+	_, ok := cache.get(r, "error")
+	assert.False(t, ok)
+	_, ok = cache.get(r, "private")
+	assert.False(t, ok)
+
+	// a is now the most recently used, b is evicted first
+	_, ok = cache.get(r, "a")
+	require.True(t, ok)
+	cache.set(&cachedResponse{key: "c", path: "/dev/c", status: http.StatusOK}, time.Minute)
+	_, ok = cache.get(r, "b")
+	assert.False(t, ok)
+
+	noCache := httptest.NewRequest(http.MethodGet, "/", nil)
+	noCache.Header.Set("Cache-Control", "no-cache")
+	_, ok = cache.get(noCache, "a")
+	assert.False(t, ok)
+
+	assert.Equal(t, 1, cache.purge("/dev/a"))
+	assert.Equal(t, 1, cache.purge(""))
+	_, ok = cache.get(r, "c")
+	assert.False(t, ok)
+}
+
+func TestResponseTTL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		cacheControl []string
+		expTTL       time.Duration
+		expOk        bool
+	}{
+		{name: "Without Cache-Control", expTTL: time.Minute, expOk: true},
+		{name: "Max age", cacheControl: []string{"public, max-age=30"}, expTTL: 30 * time.Second, expOk: true},
+		{name: "Shared max age", cacheControl: []string{"max-age=30", "s-maxage=10"}, expTTL: 10 * time.Second, expOk: true},
+		{name: "No store", cacheControl: []string{"no-store"}},
+		{name: "Zero max age", cacheControl: []string{"max-age=0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ttl, ok := responseTTL(map[string][]string{"Cache-Control": tt.cacheControl}, time.Minute)
+			assert.Equal(t, tt.expOk, ok)
+			assert.Equal(t, tt.expTTL, ttl)
+		})
+	}
+}
@@ -2,10 +2,14 @@ package proxy
 
 import (
 	"fmt"
+	"io"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"sync"
 
-	"github.com/aws/aws-sdk-go-v2/service/apigateway"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/oscarbc96/agbridge/pkg/auth"
 	"github.com/oscarbc96/agbridge/pkg/awsutils"
 	"github.com/oscarbc96/agbridge/pkg/log"
 	"github.com/samber/lo"
@@ -14,106 +18,280 @@ import (
 )
 
 type GatewayConfig struct {
-	RestAPIID   string `yaml:"rest_api_id"`
-	ProfileName string `yaml:"profile_name"`
-	Region      string `yaml:"region"`
-	StageName   string `yaml:"stage_name"`
+	RestAPIID   string `yaml:"rest_api_id" json:"rest_api_id"`
+	ProfileName string `yaml:"profile_name" json:"profile_name,omitempty"`
+	Region      string `yaml:"region" json:"region,omitempty"`
+	StageName   string `yaml:"stage_name" json:"stage_name,omitempty"`
+	// ListenAddress serves the gateway on its own address, matching paths
+	// without the stage prefix like the production base URL.
+	ListenAddress string `yaml:"listen_address" json:"listen_address,omitempty"`
+	// PathPrefix serves the routes of the gateway under this path, before the
+	// stage, so gateways with the same paths can share an address.
+	PathPrefix string `yaml:"path_prefix" json:"path_prefix,omitempty"`
+	// AllowedPrincipals restricts the gateway to these authenticated callers.
+	AllowedPrincipals []string `yaml:"allowed_principals" json:"allowed_principals,omitempty"`
+	// CORS overrides the global CORS configuration for the gateway.
+	CORS *CORSConfig `yaml:"cors" json:"cors,omitempty"`
 }
 
 type Config struct {
-	Gateways []GatewayConfig `yaml:"gateways"`
+	Gateways  []GatewayConfig     `yaml:"gateways" json:"gateways"`
+	Redaction log.RedactionConfig `yaml:"redaction" json:"redaction"`
+	Auth      auth.Config         `yaml:"auth" json:"auth"`
+	// Identities map callers to AWS identities, the first matching rule wins.
+	Identities []IdentityRule `yaml:"identities" json:"identities,omitempty"`
+	CORS       *CORSConfig    `yaml:"cors" json:"cors,omitempty"`
+	// Routes send matching requests to HTTP upstreams instead of API Gateway.
+	Routes []RouteConfig `yaml:"routes" json:"routes,omitempty"`
+	// Mocks answer matching requests locally, before any other route.
+	Mocks []MockConfig `yaml:"mocks" json:"mocks,omitempty"`
+	// Faults inject latency and failures, the first matching rule wins.
+	Faults []FaultRule `yaml:"faults" json:"faults,omitempty"`
+	// Cache stores GET and HEAD responses of API Gateway, when configured.
+	Cache *CacheConfig `yaml:"cache" json:"cache,omitempty"`
+	// FallbackUpstream receives the requests matching no route.
+	FallbackUpstream string `yaml:"fallback_upstream" json:"fallback_upstream,omitempty"`
+	// LoadConcurrency limits the gateways loaded at once, DefaultLoadConcurrency
+	// when zero.
+	LoadConcurrency int `yaml:"load_concurrency" json:"load_concurrency,omitempty"`
 }
 
+// DefaultLoadConcurrency is the number of gateways loaded at once unless the
+// configuration sets another.
+const DefaultLoadConcurrency = 4
+
 func convertPathToRegex(path string) (*regexp.Regexp, error) {
+	if path == fallbackPath {
+		return regexp.Compile(`^.*$`)
+	}
+
+	// Replace greedy `{proxy+}` with `.+`
+	greedy := regexp.MustCompile(`\{[^/]+\+\}`)
+	path = greedy.ReplaceAllString(path, `.+`)
+
 	// Replace `{param}` with `[^/]+`
 	re := regexp.MustCompile(`\{[^/]+\}`)
 	pattern := "^" + re.ReplaceAllString(path, `[^/]+`) + "$"
 	return regexp.Compile(pattern)
 }
 
-func (c *Config) Validate() (map[*regexp.Regexp]Handler, error) {
+// gatewayRoutes are the stage and resources of a gateway, as resolved from
+// API Gateway or read from the route cache.
+type gatewayRoutes struct {
+	Region         string            `json:"region"`
+	StageName      string            `json:"stage_name,omitempty"`
+	StageVariables map[string]string `json:"stage_variables,omitempty"`
+	Resources      []gatewayResource `json:"resources"`
+	// AccountID and Identity are those of the caller that resolved the
+	// routes, only kept in the route cache.
+	AccountID string `json:"account_id,omitempty"`
+	Identity  string `json:"identity,omitempty"`
+}
+
+type gatewayResource struct {
+	Path       string   `json:"path"`
+	ResourceID string   `json:"resource_id"`
+	Methods    []string `json:"methods"`
+}
+
+// resolve loads the AWS configuration, stage and resources of the gateway and
+// returns one Handler per resource exposing at least one method.
+func (gw GatewayConfig) resolve() ([]Handler, error) {
+	awsCfg, routes, err := gw.fetch()
+	if err != nil {
+		return nil, err
+	}
+	return gw.handlers(*awsCfg, routes), nil
+}
+
+// fetch loads the AWS configuration, caller identity, stage and resources of
+// the gateway.
+func (gw GatewayConfig) fetch() (*aws.Config, *gatewayRoutes, error) {
+	awsCfg, err := awsutils.LoadConfigFor(gw.ProfileName, gw.Region)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't load AWS Config for profile %s: %w", gw.ProfileName, err)
+	}
+
+	routes := &gatewayRoutes{Region: awsCfg.Region}
+
+	// Keep the identity for listing the routes without calling STS again
+	if accountID, identity, err := awsutils.GetAccountDetails(*awsCfg); err == nil {
+		routes.AccountID, routes.Identity = accountID, identity
+	} else {
+		log.Warn("Failed to get caller identity", log.Err(err), log.String("rest_api_id", gw.RestAPIID))
+	}
+
+	if gw.StageName != "" {
+		stage, err := awsutils.DescribeStage(*awsCfg, gw.RestAPIID, gw.StageName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("couldn't describe stage with name %s: %w", gw.StageName, err)
+		}
+		routes.StageName = *stage.StageName
+		routes.StageVariables = stage.Variables
+	}
+
+	resources, err := awsutils.DescribeAPIGateway(*awsCfg, gw.RestAPIID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't describe API Gateway for RestAPIID %s: %w", gw.RestAPIID, err)
+	}
+
+	for _, resource := range resources {
+		if resource.ResourceMethods == nil {
+			continue
+		}
+		routes.Resources = append(routes.Resources, gatewayResource{
+			Path:       *resource.Path,
+			ResourceID: *resource.Id,
+			Methods:    lo.Keys(resource.ResourceMethods),
+		})
+	}
+
+	return awsCfg, routes, nil
+}
+
+// handlers returns one Handler per resource of the routes.
+func (gw GatewayConfig) handlers(awsCfg aws.Config, routes *gatewayRoutes) []Handler {
+	var handlers []Handler
+	for _, resource := range routes.Resources {
+		stagePath := resource.Path
+		if routes.StageName != "" && gw.ListenAddress == "" {
+			stagePath = fmt.Sprintf("/%s%s", routes.StageName, resource.Path)
+		}
+		stagePath = gw.PathPrefix + stagePath
+
+		handlers = append(handlers, Handler{
+			StagePath:         stagePath,
+			Path:              resource.Path,
+			ResourceID:        resource.ResourceID,
+			RestAPIID:         gw.RestAPIID,
+			Methods:           resource.Methods,
+			ProfileName:       gw.ProfileName,
+			Config:            awsCfg,
+			StageVariables:    routes.StageVariables,
+			ListenAddress:     gw.ListenAddress,
+			AllowedPrincipals: gw.AllowedPrincipals,
+			AccountID:         routes.AccountID,
+			Identity:          routes.Identity,
+		})
+	}
+
+	return handlers
+}
+
+// resolveGateways resolves the gateways concurrently with resolve, up to the
+// load concurrency at once, calling resolved as soon as each gateway is done
+// when not nil. The error it returns replaces the error of the gateway. The
+// returned slices are indexed like c.Gateways.
+func (c *Config) resolveGateways(resolve func(gw GatewayConfig) ([]Handler, error), resolved func(i int, handlers []Handler, err error) error) ([][]Handler, []error) {
 	var (
-		mu     sync.Mutex
-		wg     sync.WaitGroup
-		result = make(map[*regexp.Regexp]Handler)
-		seen   = make(map[string]struct{})
-		errCh  = make(chan error, len(c.Gateways))
+		wg       sync.WaitGroup
+		handlers = make([][]Handler, len(c.Gateways))
+		errs     = make([]error, len(c.Gateways))
+		sem      = make(chan struct{}, lo.Ternary(c.LoadConcurrency > 0, c.LoadConcurrency, DefaultLoadConcurrency))
 	)
 
-	for _, gw := range c.Gateways {
+	for i, gw := range c.Gateways {
 		wg.Add(1)
-		go func(gw GatewayConfig) {
+		go func(i int, gw GatewayConfig) {
 			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
 
-			awsCfg, err := awsutils.LoadConfigFor(gw.ProfileName, gw.Region)
-			if err != nil {
-				errCh <- fmt.Errorf("couldn't load AWS Config for profile %s: %w", gw.ProfileName, err)
-				return
-			}
+			handlers[i], errs[i] = resolve(gw)
 
-			var stage *apigateway.GetStageOutput
-			var stageVariables map[string]string
-			if gw.StageName != "" {
-				stage, err = awsutils.DescribeStage(*awsCfg, gw.RestAPIID, gw.StageName)
-				if err != nil {
-					errCh <- fmt.Errorf("couldn't describe stage with name %s: %w", gw.StageName, err)
-					return
-				}
-				stageVariables = stage.Variables
+			cors := lo.CoalesceOrEmpty(gw.CORS, c.CORS)
+			for j := range handlers[i] {
+				handlers[i][j].CORS = cors
 			}
 
-			resources, err := awsutils.DescribeAPIGateway(*awsCfg, gw.RestAPIID)
-			if err != nil {
-				errCh <- fmt.Errorf("couldn't describe API Gateway for RestAPIID %s: %w", gw.RestAPIID, err)
-				return
+			if resolved != nil {
+				errs[i] = resolved(i, handlers[i], errs[i])
 			}
+		}(i, gw)
+	}
 
-			for _, resource := range resources {
-				if resource.ResourceMethods == nil {
-					continue
-				}
+	wg.Wait()
 
-				path := *resource.Path
-				stagePath := path
-				if stage != nil {
-					stagePath = fmt.Sprintf("/%s%s", *stage.StageName, path)
-				}
+	return handlers, errs
+}
 
-				regexPattern, err := convertPathToRegex(stagePath)
-				if err != nil {
-					errCh <- fmt.Errorf("invalid path %s: %w", stagePath, err)
-					return
-				}
+// buildRouteTables indexes the handlers by the regular expression matching
+// their stage path, in one route table per gateway listen address. Gateway
+// paths defined more than once in the same route table are rejected, route
+// overrides are allowed to shadow them.
+func buildRouteTables(handlers [][]Handler) (map[string]map[*regexp.Regexp]Handler, error) {
+	result := make(map[string]map[*regexp.Regexp]Handler)
+	seen := make(map[string]struct{})
 
-				mu.Lock()
-				if _, ok := seen[stagePath]; ok {
-					mu.Unlock()
-					errCh <- fmt.Errorf("duplicate path %s found in the configuration for Rest API ID %s", stagePath, gw.RestAPIID)
-					return
-				}
-				seen[stagePath] = struct{}{}
-				result[regexPattern] = Handler{
-					StagePath:      stagePath,
-					Path:           path,
-					ResourceID:     *resource.Id,
-					RestAPIID:      gw.RestAPIID,
-					Methods:        lo.Keys(resource.ResourceMethods),
-					Config:         *awsCfg,
-					StageVariables: stageVariables,
+	for _, gwHandlers := range handlers {
+		for _, handler := range gwHandlers {
+			regexPattern, err := convertPathToRegex(handler.StagePath)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %s: %w", handler.StagePath, err)
+			}
+
+			if !handler.local() {
+				key := handler.ListenAddress + " " + handler.StagePath
+				if _, ok := seen[key]; ok {
+					return nil, fmt.Errorf("duplicate path %s found in the configuration for Rest API ID %s", handler.StagePath, handler.RestAPIID)
 				}
-				mu.Unlock()
+				seen[key] = struct{}{}
 			}
-		}(gw)
+
+			if result[handler.ListenAddress] == nil {
+				result[handler.ListenAddress] = make(map[*regexp.Regexp]Handler)
+			}
+			result[handler.ListenAddress][regexPattern] = handler
+		}
 	}
 
-	wg.Wait()
-	close(errCh)
+	return result, nil
+}
 
-	if err := <-errCh; err != nil {
-		return nil, err // return first error
+// listenAddresses returns the distinct listen addresses of the gateways, route
+// overrides and mocks served on their own address.
+func (c *Config) listenAddresses() []string {
+	gateways := lo.FilterMap(c.Gateways, func(gw GatewayConfig, _ int) (string, bool) {
+		return gw.ListenAddress, gw.ListenAddress != ""
+	})
+	routes := lo.FilterMap(c.Routes, func(route RouteConfig, _ int) (string, bool) {
+		return route.ListenAddress, route.ListenAddress != ""
+	})
+	mocks := lo.FilterMap(c.Mocks, func(mock MockConfig, _ int) (string, bool) {
+		return mock.ListenAddress, mock.ListenAddress != ""
+	})
+	return lo.Uniq(append(append(gateways, routes...), mocks...))
+}
+
+// ValidateListenAddresses checks that the listen addresses of the gateways,
+// route overrides and mocks don't overlap the listen addresses of the proxy,
+// whose servers would fail to listen on them.
+func (c *Config) ValidateListenAddresses(listenAddresses []string) error {
+	for _, addr := range c.listenAddresses() {
+		for _, proxyAddr := range listenAddresses {
+			if overlappingAddresses(addr, proxyAddr) {
+				return fmt.Errorf("listen address %s of the configuration overlaps the proxy listen address %s, use another port or remove it from --listen-address", addr, proxyAddr)
+			}
+		}
 	}
+	return nil
+}
 
-	return result, nil
+// Redacted returns a copy of the configuration that is safe to expose.
+func (c *Config) Redacted() *Config {
+	return &Config{
+		Gateways:         append([]GatewayConfig(nil), c.Gateways...),
+		Redaction:        c.Redaction,
+		Auth:             c.Auth.Redacted(),
+		Identities:       lo.Map(c.Identities, func(rule IdentityRule, _ int) IdentityRule { return rule.redacted() }),
+		CORS:             c.CORS,
+		Routes:           lo.Map(c.Routes, func(route RouteConfig, _ int) RouteConfig { return route.redacted() }),
+		Mocks:            append([]MockConfig(nil), c.Mocks...),
+		Faults:           append([]FaultRule(nil), c.Faults...),
+		Cache:            c.Cache,
+		FallbackUpstream: redactURL(c.FallbackUpstream),
+		LoadConcurrency:  c.LoadConcurrency,
+	}
 }
 
 func LoadConfig(fs afero.Fs, filename string) (*Config, error) {
@@ -127,12 +305,88 @@ func LoadConfig(fs afero.Fs, filename string) (*Config, error) {
 		}
 	}()
 
+	return ReadConfig(fs, file, filepath.Dir(filename))
+}
+
+// ReadConfig parses and validates a configuration, reading the body files of
+// mocks relative to dir.
+func ReadConfig(fs afero.Fs, r io.Reader, dir string) (*Config, error) {
 	var config Config
-	decoder := yaml.NewDecoder(file)
+	decoder := yaml.NewDecoder(r)
 	if err := decoder.Decode(&config); err != nil {
 		return nil, fmt.Errorf("failed to parse Config file: %w", err)
 	}
 
+	for _, gw := range config.Gateways {
+		if gw.ListenAddress != "" {
+			if err := ValidateListenAddress(gw.ListenAddress); err != nil {
+				return nil, fmt.Errorf("invalid listen address format for Rest API ID %s: %w", gw.RestAPIID, err)
+			}
+		}
+
+		if gw.PathPrefix != "" && (gw.PathPrefix[0] != '/' || strings.HasSuffix(gw.PathPrefix, "/")) {
+			return nil, fmt.Errorf("invalid path_prefix %q for Rest API ID %s: must start with / and not end with /", gw.PathPrefix, gw.RestAPIID)
+		}
+
+		if len(gw.AllowedPrincipals) > 0 && !config.Auth.Enabled() {
+			return nil, fmt.Errorf("allowed_principals for Rest API ID %s requires auth to be configured", gw.RestAPIID)
+		}
+	}
+
+	if err := config.Auth.Validate(); err != nil {
+		return nil, err
+	}
+
+	for _, route := range config.Routes {
+		if err := route.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range config.Mocks {
+		if err := config.Mocks[i].load(fs, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.FallbackUpstream != "" {
+		if _, err := parseUpstream(config.FallbackUpstream); err != nil {
+			return nil, fmt.Errorf("invalid fallback upstream: %w", err)
+		}
+	}
+
+	if config.LoadConcurrency < 0 {
+		return nil, fmt.Errorf("invalid load_concurrency %d: must not be negative", config.LoadConcurrency)
+	}
+
+	if config.Cache != nil {
+		if err := config.Cache.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	faults := make(map[string]struct{}, len(config.Faults))
+	for _, rule := range config.Faults {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := faults[rule.Name]; ok {
+			return nil, fmt.Errorf("duplicate fault rule %s", rule.Name)
+		}
+		faults[rule.Name] = struct{}{}
+	}
+
+	names := make(map[string]struct{}, len(config.Identities))
+	for _, rule := range config.Identities {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := names[rule.Name]; ok {
+			return nil, fmt.Errorf("duplicate identity rule %s", rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+	}
+
 	return &config, nil
 }
 
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const anyOrigin = "*"
+
+type CORSConfig struct {
+	// AllowedOrigins accepts exact origins, `*` for any origin, or patterns
+	// like `http://localhost:*`.
+	AllowedOrigins []string `yaml:"allowed_origins" json:"allowed_origins"`
+	// AllowedMethods defaults to the methods of the requested resource.
+	AllowedMethods []string `yaml:"allowed_methods" json:"allowed_methods,omitempty"`
+	// AllowedHeaders accepts `*` to allow any requested header.
+	AllowedHeaders   []string `yaml:"allowed_headers" json:"allowed_headers,omitempty"`
+	ExposedHeaders   []string `yaml:"exposed_headers" json:"exposed_headers,omitempty"`
+	AllowCredentials bool     `yaml:"allow_credentials" json:"allow_credentials,omitempty"`
+	MaxAge           int      `yaml:"max_age" json:"max_age,omitempty"`
+	// ForwardPreflight sends preflight requests to the OPTIONS method of the
+	// API, like its mock integration, instead of answering them locally.
+	ForwardPreflight bool `yaml:"forward_preflight" json:"forward_preflight,omitempty"`
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+func (c *CORSConfig) originAllowed(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == anyOrigin || allowed == origin {
+			return true
+		}
+		if matched, _ := path.Match(allowed, origin); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// setHeaders adds the CORS headers of a response to a request from origin.
+func (c *CORSConfig) setHeaders(h http.Header, origin string) {
+	h.Add("Vary", "Origin")
+
+	if !c.originAllowed(origin) {
+		return
+	}
+
+	// Browsers reject the wildcard when credentials are allowed
+	if slices.Contains(c.AllowedOrigins, anyOrigin) && !c.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", anyOrigin)
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if len(c.ExposedHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+	}
+}
+
+// writePreflight answers a preflight request for a resource supporting
+// methods.
+func (c *CORSConfig) writePreflight(w http.ResponseWriter, r *http.Request, methods []string) {
+	origin := r.Header.Get("Origin")
+
+	c.setHeaders(w.Header(), origin)
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	if c.originAllowed(origin) {
+		allowedMethods := c.AllowedMethods
+		if len(allowedMethods) == 0 {
+			allowedMethods = methods
+			// Resources accepting any method allow the requested one
+			if slices.Contains(methods, anyMethod) {
+				allowedMethods = []string{r.Header.Get("Access-Control-Request-Method")}
+			}
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ", "))
+
+		if slices.Contains(c.AllowedHeaders, "*") {
+			if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				w.Header().Set("Access-Control-Allow-Headers", requested)
+			}
+		} else if len(c.AllowedHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+		}
+
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func isCORSHeader(key string) bool {
+	return strings.HasPrefix(http.CanonicalHeaderKey(key), "Access-Control-")
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORSWritePreflight(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		cors       CORSConfig
+		origin     string
+		expHeaders map[string]string
+	}{
+		{
+			name:   "Any origin",
+			cors:   CORSConfig{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}, MaxAge: 600},
+			origin: "http://localhost:3000",
+			expHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "*",
+				"Access-Control-Allow-Methods": "GET, POST",
+				"Access-Control-Allow-Headers": "Content-Type, Authorization",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:   "Origin pattern with credentials",
+			cors:   CORSConfig{AllowedOrigins: []string{"http://localhost:*"}, AllowedMethods: []string{"GET"}, AllowedHeaders: []string{"Content-Type"}, AllowCredentials: true},
+			origin: "http://localhost:5173",
+			expHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "http://localhost:5173",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Allow-Methods":     "GET",
+				"Access-Control-Allow-Headers":     "Content-Type",
+			},
+		},
+		{
+			name:   "Wildcard with credentials reflects origin",
+			cors:   CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			origin: "http://localhost:3000",
+			expHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "http://localhost:3000",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+		{
+			name:   "Origin not allowed",
+			cors:   CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			origin: "http://localhost:3000",
+			expHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "",
+				"Access-Control-Allow-Methods": "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodOptions, "/orders", nil)
+			r.Header.Set("Origin", tt.origin)
+			r.Header.Set("Access-Control-Request-Method", "POST")
+			r.Header.Set("Access-Control-Request-Headers", "Content-Type, Authorization")
+			assert.True(t, isPreflight(r))
+
+			w := httptest.NewRecorder()
+			tt.cors.writePreflight(w, r, []string{"GET", "POST"})
+
+			assert.Equal(t, http.StatusNoContent, w.Code)
+			for key, value := range tt.expHeaders {
+				assert.Equal(t, value, w.Header().Get(key), key)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/samber/lo"
+)
+
+// DefaultFaultTimeout is how long API Gateway waits for an integration before
+// answering 504.
+const DefaultFaultTimeout = 29 * time.Second
+
+// statusClientClosedRequest is reported for the requests canceled by the
+// client while a fault delays them, as no response is sent.
+const statusClientClosedRequest = 499
+
+// FaultMatch selects the requests a fault rule applies to. Every non-empty
+// field must match.
+type FaultMatch struct {
+	// Path is an API Gateway path template, like `/dev/users/{id}`.
+	Path      string   `yaml:"path" json:"path,omitempty"`
+	Methods   []string `yaml:"methods" json:"methods,omitempty"`
+	RestAPIID string   `yaml:"rest_api_id" json:"rest_api_id,omitempty"`
+}
+
+// LatencyConfig delays requests by a fixed duration, a duration uniformly
+// distributed between Min and Max, or normally distributed around Mean.
+type LatencyConfig struct {
+	Fixed  time.Duration `yaml:"fixed" json:"fixed,omitempty"`
+	Min    time.Duration `yaml:"min" json:"min,omitempty"`
+	Max    time.Duration `yaml:"max" json:"max,omitempty"`
+	Mean   time.Duration `yaml:"mean" json:"mean,omitempty"`
+	StdDev time.Duration `yaml:"stddev" json:"stddev,omitempty"`
+}
+
+// FaultRule adds latency to the matching requests and fails a percentage of
+// them with one of the status codes, a simulated API Gateway timeout or a
+// dropped connection.
+type FaultRule struct {
+	Name     string         `yaml:"name" json:"name"`
+	Disabled bool           `yaml:"disabled" json:"disabled,omitempty"`
+	Match    FaultMatch     `yaml:"match" json:"match"`
+	Latency  *LatencyConfig `yaml:"latency" json:"latency,omitempty"`
+	// Percentage of the matching requests failing, all of them when unset.
+	Percentage     *float64      `yaml:"percentage" json:"percentage,omitempty"`
+	StatusCodes    []int         `yaml:"status_codes" json:"status_codes,omitempty"`
+	Timeout        bool          `yaml:"timeout" json:"timeout,omitempty"`
+	TimeoutAfter   time.Duration `yaml:"timeout_after" json:"timeout_after,omitempty"`
+	DropConnection bool          `yaml:"drop_connection" json:"drop_connection,omitempty"`
+}
+
+// FaultStatus reports whether a fault rule is being injected.
+type FaultStatus struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+func (l *LatencyConfig) validate() error {
+	kinds := lo.Count([]bool{l.Fixed > 0, l.Min > 0 || l.Max > 0, l.Mean > 0 || l.StdDev > 0}, true)
+	if kinds != 1 {
+		return errors.New("latency requires one of fixed, min and max, or mean and stddev")
+	}
+	if l.Min > l.Max {
+		return errors.New("latency min can't be greater than max")
+	}
+	return nil
+}
+
+func (l *LatencyConfig) sample() time.Duration {
+	switch {
+	case l.Fixed > 0:
+		return l.Fixed
+	case l.Max > 0:
+		return l.Min + rand.N(l.Max-l.Min+1)
+	default:
+		return max(0, l.Mean+time.Duration(rand.NormFloat64()*float64(l.StdDev)))
+	}
+}
+
+func (rule FaultRule) validate() error {
+	if rule.Name == "" {
+		return errors.New("fault rule without name")
+	}
+	if rule.Match.Path != "" {
+		if _, err := convertPathToRegex(rule.Match.Path); err != nil || rule.Match.Path[0] != '/' {
+			return fmt.Errorf("invalid path %q for fault rule %s", rule.Match.Path, rule.Name)
+		}
+	}
+	if rule.Latency != nil {
+		if err := rule.Latency.validate(); err != nil {
+			return fmt.Errorf("fault rule %s: %w", rule.Name, err)
+		}
+	}
+
+	failures := lo.Count([]bool{len(rule.StatusCodes) > 0, rule.Timeout, rule.DropConnection}, true)
+	if failures > 1 {
+		return fmt.Errorf("fault rule %s can only use one of status_codes, timeout and drop_connection", rule.Name)
+	}
+	if failures == 0 && rule.Latency == nil {
+		return fmt.Errorf("fault rule %s requires latency, status_codes, timeout or drop_connection", rule.Name)
+	}
+	if p := lo.FromPtrOr(rule.Percentage, 100); p < 0 || p > 100 {
+		return fmt.Errorf("percentage of fault rule %s must be between 0 and 100", rule.Name)
+	}
+	for _, code := range rule.StatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid status code %d for fault rule %s", code, rule.Name)
+		}
+	}
+	return nil
+}
+
+// faultInjector applies the first enabled fault rule matching a request.
+// Rules are toggled at runtime through the admin API.
+type faultInjector struct {
+	rules    []FaultRule
+	patterns []*regexp.Regexp
+	enabled  []atomic.Bool
+}
+
+func newFaultInjector(rules []FaultRule) *faultInjector {
+	f := &faultInjector{
+		rules:    rules,
+		patterns: make([]*regexp.Regexp, len(rules)),
+		enabled:  make([]atomic.Bool, len(rules)),
+	}
+
+	for i, rule := range rules {
+		if rule.Match.Path != "" {
+			// Paths are validated when loading the configuration
+			f.patterns[i], _ = convertPathToRegex(rule.Match.Path)
+		}
+		f.enabled[i].Store(!rule.Disabled)
+	}
+
+	return f
+}
+
+func (f *faultInjector) matches(i int, r *http.Request, handler *Handler) bool {
+	m := f.rules[i].Match
+
+	if m.RestAPIID != "" && m.RestAPIID != handler.RestAPIID {
+		return false
+	}
+	if len(m.Methods) > 0 && !lo.Contains(m.Methods, r.Method) {
+		return false
+	}
+	if f.patterns[i] != nil && !f.patterns[i].MatchString(getPath(r.URL)) {
+		return false
+	}
+	return true
+}
+
+// inject applies the fault rule matching the request, if any. It returns the
+// status of the response and true when the request must not be processed
+// further.
+func (f *faultInjector) inject(w http.ResponseWriter, r *http.Request, handler *Handler) (int, bool) {
+	if f == nil {
+		return 0, false
+	}
+
+	i, ok := lo.Find(lo.Range(len(f.rules)), func(i int) bool {
+		return f.enabled[i].Load() && f.matches(i, r, handler)
+	})
+	if !ok {
+		return 0, false
+	}
+
+	rule := f.rules[i]
+	logger := log.FromContext(r.Context()).With(log.String("fault", rule.Name))
+
+	if rule.Latency != nil {
+		delay := rule.Latency.sample()
+		logger.Debug("Injecting latency", log.Duration("delay", delay))
+		if !sleep(r.Context(), delay) {
+			return statusClientClosedRequest, true
+		}
+	}
+
+	if rand.Float64()*100 >= lo.FromPtrOr(rule.Percentage, 100) {
+		return 0, false
+	}
+
+	switch {
+	case len(rule.StatusCodes) > 0:
+		status := rule.StatusCodes[rand.IntN(len(rule.StatusCodes))]
+		logger.Debug("Injecting status code", log.Int("status_code", status))
+		http.Error(w, fmt.Sprintf("Raised from AGBridge: Injected fault %s Request ID: %s", rule.Name, r.Header.Get(RequestIDHeader)), status)
+		return status, true
+
+	case rule.Timeout:
+		timeout := lo.CoalesceOrEmpty(rule.TimeoutAfter, DefaultFaultTimeout)
+		logger.Debug("Injecting timeout", log.Duration("timeout", timeout))
+		if !sleep(r.Context(), timeout) {
+			return statusClientClosedRequest, true
+		}
+		// Same response as API Gateway when the integration times out
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Amzn-ErrorType", "InternalServerErrorException")
+		w.WriteHeader(http.StatusGatewayTimeout)
+		_, _ = w.Write([]byte(`{"message": "Endpoint request timed out"}`))
+		return http.StatusGatewayTimeout, true
+
+	case rule.DropConnection:
+		logger.Debug("Dropping connection")
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			// Aborts the response without logging, like a dropped connection
+			panic(http.ErrAbortHandler)
+		}
+		_ = conn.Close()
+		// No response is sent, reported like API Gateway failing to reach
+		// the integration
+		return http.StatusServiceUnavailable, true
+	}
+
+	return 0, false
+}
+
+// sleep waits for d and returns false when the request is canceled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// status returns whether each fault rule is enabled.
+func (f *faultInjector) status() []FaultStatus {
+	return lo.Map(f.rules, func(rule FaultRule, i int) FaultStatus {
+		return FaultStatus{Name: rule.Name, Enabled: f.enabled[i].Load()}
+	})
+}
+
+// setEnabled toggles the named fault rule and reports whether it exists.
+func (f *faultInjector) setEnabled(name string, enabled bool) bool {
+	i := lo.IndexOf(lo.Map(f.rules, func(rule FaultRule, _ int) string { return rule.Name }), name)
+	if i < 0 {
+		return false
+	}
+	f.enabled[i].Store(enabled)
+	return true
+}
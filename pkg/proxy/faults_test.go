@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigFaults(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "agbridge.yaml", []byte(`
+faults:
+  - name: slow-users
+    match:
+      path: /dev/users/{id}
+      methods: [GET]
+    latency:
+      min: 100ms
+      max: 2s
+    percentage: 10
+    timeout: true
+    timeout_after: 5s
+`), 0o644))
+
+	config, err := LoadConfig(fs, "agbridge.yaml")
+	require.NoError(t, err)
+	require.Len(t, config.Faults, 1)
+	assert.Equal(t, 100*time.Millisecond, config.Faults[0].Latency.Min)
+	assert.Equal(t, 2*time.Second, config.Faults[0].Latency.Max)
+	assert.Equal(t, 5*time.Second, config.Faults[0].TimeoutAfter)
+	assert.InDelta(t, 10, *config.Faults[0].Percentage, 0)
+}
+
+func TestFaultRuleValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		rule   FaultRule
+		expErr string
+	}{
+		{
+			name: "Valid",
+			rule: FaultRule{Name: "errors", StatusCodes: []int{500, 503}, Percentage: lo.ToPtr(25.0)},
+		},
+		{
+			name:   "Without name",
+			rule:   FaultRule{Timeout: true},
+			expErr: "fault rule without name",
+		},
+		{
+			name:   "Without fault",
+			rule:   FaultRule{Name: "noop"},
+			expErr: "fault rule noop requires latency, status_codes, timeout or drop_connection",
+		},
+		{
+			name:   "Several failures",
+			rule:   FaultRule{Name: "both", Timeout: true, DropConnection: true},
+			expErr: "fault rule both can only use one of status_codes, timeout and drop_connection",
+		},
+		{
+			name:   "Several latency distributions",
+			rule:   FaultRule{Name: "latency", Latency: &LatencyConfig{Fixed: time.Second, Mean: time.Second}},
+			expErr: "fault rule latency: latency requires one of fixed, min and max, or mean and stddev",
+		},
+		{
+			name:   "Invalid percentage",
+			rule:   FaultRule{Name: "percentage", Timeout: true, Percentage: lo.ToPtr(150.0)},
+			expErr: "percentage of fault rule percentage must be between 0 and 100",
+		},
+		{
+			name:   "Invalid status code",
+			rule:   FaultRule{Name: "status", StatusCodes: []int{42}},
+			expErr: "invalid status code 42 for fault rule status",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.rule.validate()
+			if tt.expErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tt.expErr)
+		})
+	}
+}
+
+func TestLatencySample(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, time.Second, (&LatencyConfig{Fixed: time.Second}).sample())
+
+	uniform := &LatencyConfig{Min: time.Millisecond, Max: 3 * time.Millisecond}
+	normal := &LatencyConfig{Mean: time.Millisecond, StdDev: 10 * time.Millisecond}
+	for range 100 {
+		assert.GreaterOrEqual(t, uniform.sample(), time.Millisecond)
+		assert.LessOrEqual(t, uniform.sample(), 3*time.Millisecond)
+		assert.GreaterOrEqual(t, normal.sample(), time.Duration(0))
+	}
+}
+
+func TestFaultInjectorInject(t *testing.T) {
+	t.Parallel()
+
+	faults := newFaultInjector([]FaultRule{
+		{Name: "errors", Match: FaultMatch{Path: "/dev/users/{id}", Methods: []string{"POST"}}, StatusCodes: []int{503}},
+		{Name: "timeouts", Match: FaultMatch{RestAPIID: "api"}, Timeout: true, TimeoutAfter: time.Millisecond},
+		{Name: "never", Match: FaultMatch{RestAPIID: "other"}, DropConnection: true, Percentage: lo.ToPtr(0.0)},
+	})
+	handler := &Handler{StagePath: "/dev/users/{id}", RestAPIID: "api"}
+
+	w := httptest.NewRecorder()
+	status, ok := faults.inject(w, httptest.NewRequest(http.MethodPost, "/dev/users/42", nil), handler)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w = httptest.NewRecorder()
+	status, ok = faults.inject(w, httptest.NewRequest(http.MethodGet, "/dev/users/42", nil), handler)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusGatewayTimeout, status)
+	assert.JSONEq(t, `{"message": "Endpoint request timed out"}`, w.Body.String())
+
+	// Canceled requests aren't reported without a status
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	status, ok = faults.inject(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/dev/users/42", nil).WithContext(ctx), handler)
+	assert.True(t, ok)
+	assert.Equal(t, statusClientClosedRequest, status)
+
+	require.True(t, faults.setEnabled("timeouts", false))
+	_, ok = faults.inject(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/dev/users/42", nil), handler)
+	assert.False(t, ok)
+
+	_, ok = faults.inject(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), &Handler{RestAPIID: "other"})
+	assert.False(t, ok)
+
+	assert.False(t, faults.setEnabled("missing", true))
+	assert.Equal(t, []FaultStatus{
+		{Name: "errors", Enabled: true},
+		{Name: "timeouts", Enabled: false},
+		{Name: "never", Enabled: true},
+	}, faults.status())
+}
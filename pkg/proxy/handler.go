@@ -6,13 +6,14 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/apigateway"
+	"github.com/oscarbc96/agbridge/pkg/auth"
 	"github.com/oscarbc96/agbridge/pkg/log"
-	"github.com/samber/lo"
 )
 
 type Handler struct {
@@ -21,33 +22,109 @@ type Handler struct {
 	ResourceID     string
 	RestAPIID      string
 	Methods        []string
+	ProfileName    string
 	Config         aws.Config
 	StageVariables map[string]string
+	ListenAddress  string
+	// AllowedPrincipals are the callers allowed to use the handler, any
+	// authenticated caller when empty.
+	AllowedPrincipals []string
+	// CORS answers preflight requests and adds CORS headers to responses,
+	// when configured.
+	CORS *CORSConfig
+	// Upstream receives the requests instead of API Gateway, for route
+	// overrides and the fallback upstream.
+	Upstream    *url.URL
+	StripPrefix string
+	// Fallback is set on the handler of the fallback upstream, used when no
+	// other handler matches.
+	Fallback bool
+	// Mock answers the requests instead of API Gateway.
+	Mock *MockConfig
+	// AccountID and Identity are those of the caller that resolved the
+	// handler, recorded when it loads or read from the route cache.
+	AccountID string
+	Identity  string
 }
 
-func defaultHandleRequest(w http.ResponseWriter, r *http.Request, handlerMapping map[*regexp.Regexp]Handler) {
-	start := time.Now()
+// local reports whether the handler answers requests without API Gateway.
+func (h *Handler) local() bool {
+	return h.Upstream != nil || h.Mock != nil
+}
 
-	path := getPath(r.URL)
+func defaultHandleRequest(w http.ResponseWriter, r *http.Request, handlerMapping map[*regexp.Regexp]Handler, identities *identityResolver, faults *faultInjector, cache *responseCache) {
+	start := time.Now()
 
 	var handler *Handler
-	for pattern, h := range handlerMapping {
-		if pattern.MatchString(path) {
-			handler = &h
-			break
-		}
-	}
+	status := http.StatusInternalServerError
+	defer func() { setRequestStatus(r, status) }()
 
+	handler = findHandler(handlerMapping, getPath(r.URL), r.Method)
 	if handler == nil {
 		handleError(w, r, nil, "Handler not found")
 		return
 	}
 
-	if !lo.Contains(handler.Methods, r.Method) {
+	setRequestRoute(r, handler)
+
+	if principal := auth.FromContext(r.Context()); principal != nil && !auth.Allowed(principal, handler.AllowedPrincipals) {
+		auditDenied(r, principal, handler.RestAPIID, "principal not allowed for gateway")
+		status = http.StatusForbidden
+		writeError(w, r, status, nil, "Forbidden")
+		return
+	}
+
+	origin := r.Header.Get("Origin")
+	if handler.CORS != nil && origin != "" {
+		if isPreflight(r) && !handler.CORS.ForwardPreflight {
+			status = http.StatusNoContent
+			handler.CORS.writePreflight(w, r, handler.Methods)
+			return
+		}
+		handler.CORS.setHeaders(w.Header(), origin)
+	}
+
+	if isUnauthenticatedPreflight(r) {
+		auditDenied(r, nil, handler.RestAPIID, "preflight request can't be forwarded without credentials")
+		status = http.StatusUnauthorized
+		writeError(w, r, status, nil, "Unauthorized")
+		return
+	}
+
+	if !handler.supportsMethod(r.Method) {
 		handleError(w, r, nil, "Method not supported")
 		return
 	}
 
+	if injected, ok := faults.inject(w, r, handler); ok {
+		status = injected
+		return
+	}
+
+	if handler.Mock != nil {
+		status = serveMock(w, r, handler.Mock)
+		log.FromContext(r.Context()).Info(
+			r.URL.String(),
+			log.String("method", r.Method),
+			log.Bool("mock", true),
+			log.Int("status_code", status),
+			log.Duration("elapsed_ms", time.Since(start)),
+		)
+		return
+	}
+
+	if handler.Upstream != nil {
+		status = serveUpstream(w, r, handler)
+		log.FromContext(r.Context()).Info(
+			r.URL.String(),
+			log.String("method", r.Method),
+			log.String("upstream", handler.Upstream.Redacted()),
+			log.Int("status_code", status),
+			log.Duration("elapsed_ms", time.Since(start)),
+		)
+		return
+	}
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		handleError(w, r, err, "Error reading request body")
@@ -59,62 +136,131 @@ func defaultHandleRequest(w http.ResponseWriter, r *http.Request, handlerMapping
 		pathWithQuery += "?" + rawQuery
 	}
 
-	log.Debug("Sending request to API Gateway" +
+	awsCfg, identity, err := identities.configFor(r, handler)
+	if err != nil {
+		handleError(w, r, err, "Error loading AWS identity")
+		return
+	}
+
+	logger := log.FromContext(r.Context())
+	if identity != "" {
+		logger = logger.With(log.String("identity", identity))
+	}
+
+	logger.Debug("Sending request to API Gateway" +
 		"\nProxy URL: " + r.URL.String() +
 		"\nResource ID: " + handler.ResourceID +
 		"\nREST API ID: " + handler.RestAPIID +
 		"\nMethod: " + r.Method +
 		"\nURL: " + pathWithQuery +
-		"\nBody: " + string(body) +
-		"\nHeaders: " + fmt.Sprint(r.Header) +
-		"\nStage Variables: " + fmt.Sprint(handler.StageVariables),
+		"\nBody: " + log.RedactBody(body) +
+		"\nHeaders: " + fmt.Sprint(log.RedactHeaders(r.Header)) +
+		"\nStage Variables: " + fmt.Sprint(log.RedactStageVariables(handler.StageVariables)),
 	)
 
-	client := apigateway.NewFromConfig(handler.Config)
+	cacheKey, ttl, cacheable := cache.key(r, handler, identity)
+	if cacheable {
+		if entry, ok := cache.get(r, cacheKey); ok {
+			observeCache(true)
+			w.Header().Set(CacheHeader, "HIT")
+			w.Header().Set("Age", strconv.Itoa(int(time.Since(entry.stored).Seconds())))
+			status = entry.status
+			if err := writeResponse(w, r, handler, status, entry.headers, entry.body); err != nil {
+				logger.Error("Error copying response body", log.Err(err), log.String("path", r.URL.String()), log.String("method", r.Method))
+				return
+			}
+			logger.Info(
+				r.URL.String(),
+				log.String("method", r.Method),
+				log.String("cache", "hit"),
+				log.Int("status_code", status),
+				log.Duration("elapsed_ms", time.Since(start)),
+			)
+			return
+		}
+		observeCache(false)
+		w.Header().Set(CacheHeader, "MISS")
+	}
+
+	client := apigateway.NewFromConfig(awsCfg, withRetryMetrics(handler.RestAPIID))
+	invokeCtx, invokeSpan, headers := startInvokeSpan(r.Context(), r.Header)
 	resp, err := client.TestInvokeMethod(
-		r.Context(),
+		invokeCtx,
 		&apigateway.TestInvokeMethodInput{
 			ResourceId:          &handler.ResourceID,
 			RestApiId:           &handler.RestAPIID,
 			HttpMethod:          &r.Method,
 			PathWithQueryString: aws.String(pathWithQuery),
 			Body:                aws.String(string(body)),
-			MultiValueHeaders:   r.Header,
+			MultiValueHeaders:   headers,
 			StageVariables:      handler.StageVariables,
 		},
 	)
+	endInvokeSpan(invokeSpan, err)
 	if err != nil {
+		observeAWSError(handler.RestAPIID, err)
 		handleError(w, r, err, "Error calling API Gateway")
 		return
 	}
 
-	log.Debug("Received response from API Gateway:\n" + *resp.Log)
+	// The execution log embeds the request and response headers and bodies
+	executionLog := log.RedactText(aws.ToString(resp.Log), handler.StageVariables,
+		[]http.Header{r.Header, resp.MultiValueHeaders},
+		body, []byte(aws.ToString(resp.Body)),
+	)
+	logger.Debug("Received response from API Gateway:\n" + executionLog)
+	setExecutionLog(r.Context(), executionLog)
 
-	// Copy the headers from test-invoke response to the proxy response
-	for key, values := range resp.MultiValueHeaders {
-		for _, value := range values {
-			w.Header().Add(key, value)
-		}
+	status = int(resp.Status)
+	if cacheable {
+		cache.set(&cachedResponse{
+			key:     cacheKey,
+			path:    getPath(r.URL),
+			status:  status,
+			headers: resp.MultiValueHeaders,
+			body:    *resp.Body,
+		}, ttl)
 	}
 
-	// Copy the body from test-invoke response to the proxy response
-	_, err = io.Copy(w, strings.NewReader(*resp.Body))
-	if err != nil {
-		handleError(w, r, err, "Error copying response body")
+	if err := writeResponse(w, r, handler, status, resp.MultiValueHeaders, *resp.Body); err != nil {
+		logger.Error("Error copying response body", log.Err(err), log.String("path", r.URL.String()), log.String("method", r.Method))
 		return
 	}
 
-	// Copy HTTP status code from test-invoke response to the proxy response
-	w.WriteHeader(int(resp.Status))
-
-	log.Info(
+	logger.Info(
 		r.URL.String(),
 		log.String("method", r.Method),
-		log.Int("status_code", int(resp.Status)),
+		log.Int("status_code", status),
 		log.Duration("elapsed_ms", time.Since(start)),
 	)
 }
 
+// writeResponse copies the headers, status code and body of a test-invoke
+// response to the proxy response.
+func writeResponse(w http.ResponseWriter, r *http.Request, handler *Handler, status int, headers map[string][]string, body string) error {
+	origin := r.Header.Get("Origin")
+
+	for key, values := range headers {
+		for _, value := range values {
+			// The request ID of the proxy replaces the one of the API
+			if http.CanonicalHeaderKey(key) == RequestIDHeader && w.Header().Get(RequestIDHeader) != "" {
+				continue
+			}
+			// CORS headers set by agbridge take precedence over the API ones
+			if handler.CORS != nil && origin != "" && isCORSHeader(key) {
+				continue
+			}
+			w.Header().Add(key, value)
+		}
+	}
+
+	// The status can't be changed once the body has been written
+	w.WriteHeader(status)
+
+	_, err := io.Copy(w, strings.NewReader(body))
+	return err
+}
+
 func getPath(u *url.URL) string {
 	uCopy := *u
 	uCopy.RawQuery = ""
@@ -122,24 +268,32 @@ func getPath(u *url.URL) string {
 }
 
 func handleError(w http.ResponseWriter, r *http.Request, err error, message string) {
-	logger := log.With(
+	writeError(w, r, http.StatusInternalServerError, err, message)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, err error, message string) {
+	logger := log.FromContext(r.Context()).With(
 		log.String("path", r.URL.String()),
 		log.String("method", r.Method),
 	)
 
+	requestID := r.Header.Get(RequestIDHeader)
+
 	if err != nil {
 		http.Error(
 			w,
-			fmt.Sprintf("Raised from AGBridge: %s Error: %s", message, err.Error()),
-			http.StatusInternalServerError,
+			fmt.Sprintf("Raised from AGBridge: %s Error: %s Request ID: %s", message, err.Error(), requestID),
+			status,
 		)
 		logger.Error(message, log.Err(err))
 	} else {
 		http.Error(
 			w,
-			fmt.Sprintf("Raised from AGBridge: %s", message),
-			http.StatusInternalServerError,
+			fmt.Sprintf("Raised from AGBridge: %s Request ID: %s", message, requestID),
+			status,
 		)
-		logger.Error(message)
+		if status >= http.StatusInternalServerError {
+			logger.Error(message)
+		}
 	}
 }
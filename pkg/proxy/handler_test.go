@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testInvokeResponse is the body of a TestInvokeMethod response.
+type testInvokeResponse struct {
+	Status            int                 `json:"status"`
+	Body              string              `json:"body"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	Log               string              `json:"log,omitempty"`
+}
+
+// newFakeAPIGateway returns the AWS configuration of an API Gateway answering
+// every call with handle. Failed calls are retried once, without delay.
+func newFakeAPIGateway(t *testing.T, handle http.HandlerFunc) aws.Config {
+	t.Helper()
+
+	server := httptest.NewServer(handle)
+	t.Cleanup(server.Close)
+
+	return aws.Config{
+		Region:       "eu-west-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("AKIDEXAMPLE", "secret", ""),
+		BaseEndpoint: aws.String(server.URL),
+		Retryer: func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.MaxAttempts = 2
+				o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+			})
+		},
+	}
+}
+
+// respondTestInvoke answers TestInvokeMethod calls with resp.
+func respondTestInvoke(resp testInvokeResponse) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// testInvokeMapping returns the handler mapping of a single API Gateway route
+// sent to awsCfg.
+func testInvokeMapping(awsCfg aws.Config, restAPIID string) map[*regexp.Regexp]Handler {
+	return map[*regexp.Regexp]Handler{
+		regexp.MustCompile(`^/dev/users$`): {
+			StagePath:  "/dev/users",
+			Path:       "/users",
+			ResourceID: "res123",
+			RestAPIID:  restAPIID,
+			Methods:    []string{http.MethodPost},
+			Config:     awsCfg,
+		},
+	}
+}
+
+func TestDefaultHandleRequestStatus(t *testing.T) {
+	t.Parallel()
+
+	awsCfg := newFakeAPIGateway(t, respondTestInvoke(testInvokeResponse{
+		Status:            http.StatusCreated,
+		Body:              `{"id": "1"}`,
+		MultiValueHeaders: map[string][]string{"Content-Type": {"application/json"}},
+	}))
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/dev/users", nil)
+	defaultHandleRequest(rec, r, testInvokeMapping(awsCfg, "status"), newIdentityResolver(nil), nil, nil)
+
+	// The status is written before the body, which would otherwise send 200
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.JSONEq(t, `{"id": "1"}`, rec.Body.String())
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+}
+
+func TestWriteResponse(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/dev/users", nil)
+	err := writeResponse(rec, r, &Handler{}, http.StatusAccepted, map[string][]string{"X-Custom": {"a", "b"}}, "queued")
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "queued", rec.Body.String())
+	assert.Equal(t, []string{"a", "b"}, rec.Header().Values("X-Custom"))
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/oscarbc96/agbridge/pkg/auth"
+	"github.com/oscarbc96/agbridge/pkg/awsutils"
+	"github.com/oscarbc96/agbridge/pkg/log"
+)
+
+// IdentityMatch selects the requests an identity rule applies to. Every
+// non-empty field must match.
+type IdentityMatch struct {
+	// Principal is the name of the authenticated caller: the token name, the
+	// basic username, the client certificate common name or the JWT subject.
+	Principal    string `yaml:"principal" json:"principal,omitempty"`
+	ClientCertCN string `yaml:"client_cert_cn" json:"client_cert_cn,omitempty"`
+	Header       string `yaml:"header" json:"header,omitempty"`
+	HeaderValue  string `yaml:"header_value" json:"header_value,omitempty"`
+	RestAPIID    string `yaml:"rest_api_id" json:"rest_api_id,omitempty"`
+}
+
+// IdentityRule maps the matching requests to an AWS profile, a role assumed
+// with the profile or gateway credentials, or both.
+type IdentityRule struct {
+	Name        string        `yaml:"name" json:"name"`
+	Match       IdentityMatch `yaml:"match" json:"match"`
+	ProfileName string        `yaml:"profile_name" json:"profile_name,omitempty"`
+	RoleARN     string        `yaml:"role_arn" json:"role_arn,omitempty"`
+	ExternalID  string        `yaml:"external_id" json:"external_id,omitempty"`
+	SessionName string        `yaml:"session_name" json:"session_name,omitempty"`
+}
+
+func (rule IdentityRule) validate() error {
+	if rule.Name == "" {
+		return errors.New("identity rule without name")
+	}
+	if rule.ProfileName == "" && rule.RoleARN == "" {
+		return fmt.Errorf("identity rule %s requires profile_name or role_arn", rule.Name)
+	}
+	if rule.Match == (IdentityMatch{}) {
+		return fmt.Errorf("identity rule %s requires at least one match condition", rule.Name)
+	}
+	if (rule.Match.Header == "") != (rule.Match.HeaderValue == "") {
+		return fmt.Errorf("identity rule %s requires header and header_value together", rule.Name)
+	}
+	return nil
+}
+
+// redacted returns a copy of the rule without the header value and external
+// ID, which are shared secrets.
+func (rule IdentityRule) redacted() IdentityRule {
+	if rule.Match.HeaderValue != "" {
+		rule.Match.HeaderValue = log.Redacted
+	}
+	if rule.ExternalID != "" {
+		rule.ExternalID = log.Redacted
+	}
+	return rule
+}
+
+func (rule IdentityRule) matches(r *http.Request, handler *Handler) bool {
+	m := rule.Match
+
+	if m.RestAPIID != "" && m.RestAPIID != handler.RestAPIID {
+		return false
+	}
+
+	if m.Principal != "" {
+		principal := auth.FromContext(r.Context())
+		if principal == nil || principal.Name != m.Principal {
+			return false
+		}
+	}
+
+	if m.ClientCertCN != "" {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || r.TLS.VerifiedChains[0][0].Subject.CommonName != m.ClientCertCN {
+			return false
+		}
+	}
+
+	if m.Header != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(m.Header)), []byte(m.HeaderValue)) != 1 {
+		return false
+	}
+
+	return true
+}
+
+// identityResolver picks the AWS configuration used to call a gateway on
+// behalf of a request. Configurations are loaded on first use and cached per
+// rule and gateway, until reset.
+type identityResolver struct {
+	rules []IdentityRule
+
+	mu      sync.Mutex
+	configs map[string]aws.Config
+}
+
+func newIdentityResolver(rules []IdentityRule) *identityResolver {
+	return &identityResolver{rules: rules, configs: make(map[string]aws.Config)}
+}
+
+// configFor returns the AWS configuration of the first rule matching the
+// request and the rule name, or the gateway configuration when none does.
+func (ir *identityResolver) configFor(r *http.Request, handler *Handler) (aws.Config, string, error) {
+	for _, rule := range ir.rules {
+		if !rule.matches(r, handler) {
+			continue
+		}
+
+		cfg, err := ir.load(rule, handler)
+		return cfg, rule.Name, err
+	}
+
+	return handler.Config, "", nil
+}
+
+// reset drops the cached configurations, so profiles are loaded again.
+func (ir *identityResolver) reset() {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+
+	clear(ir.configs)
+}
+
+func (ir *identityResolver) load(rule IdentityRule, handler *Handler) (aws.Config, error) {
+	// Gateways sharing a Rest API ID can use other profiles and regions
+	key := strings.Join([]string{rule.Name, handler.RestAPIID, handler.ProfileName, handler.Config.Region}, "\x00")
+
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+
+	if cfg, ok := ir.configs[key]; ok {
+		return cfg, nil
+	}
+
+	cfg := handler.Config
+	if rule.ProfileName != "" {
+		profileCfg, err := awsutils.LoadConfigFor(rule.ProfileName, handler.Config.Region)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("couldn't load AWS Config for identity %s: %w", rule.Name, err)
+		}
+		cfg = *profileCfg
+	}
+
+	if rule.RoleARN != "" {
+		sessionName := rule.SessionName
+		if sessionName == "" {
+			sessionName = "agbridge-" + rule.Name
+		}
+		cfg = awsutils.AssumeRole(cfg, rule.RoleARN, rule.ExternalID, sessionName)
+	}
+
+	ir.configs[key] = cfg
+
+	return cfg, nil
+}
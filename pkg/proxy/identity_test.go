@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/oscarbc96/agbridge/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityResolverConfigFor(t *testing.T) {
+	t.Parallel()
+
+	resolver := newIdentityResolver([]IdentityRule{
+		{Name: "payments", Match: IdentityMatch{Header: "X-Team", HeaderValue: "payments"}, RoleARN: "arn:aws:iam::123456789012:role/payments"},
+		{Name: "ci", Match: IdentityMatch{Principal: "ci", RestAPIID: "abc123"}, RoleARN: "arn:aws:iam::123456789012:role/ci"},
+	})
+	handler := &Handler{RestAPIID: "abc123", Config: aws.Config{Region: "eu-west-1"}}
+
+	tests := []struct {
+		name        string
+		setup       func(r *http.Request) *http.Request
+		handler     *Handler
+		expIdentity string
+	}{
+		{
+			name:        "No rule matches",
+			setup:       func(r *http.Request) *http.Request { return r },
+			handler:     handler,
+			expIdentity: "",
+		},
+		{
+			name: "Header rule",
+			setup: func(r *http.Request) *http.Request {
+				r.Header.Set("X-Team", "payments")
+				return r
+			},
+			handler:     handler,
+			expIdentity: "payments",
+		},
+		{
+			name: "Principal rule",
+			setup: func(r *http.Request) *http.Request {
+				return r.WithContext(auth.NewContext(r.Context(), &auth.Principal{Name: "ci", Method: auth.MethodToken}))
+			},
+			handler:     handler,
+			expIdentity: "ci",
+		},
+		{
+			name: "Principal rule for another gateway",
+			setup: func(r *http.Request) *http.Request {
+				return r.WithContext(auth.NewContext(r.Context(), &auth.Principal{Name: "ci", Method: auth.MethodToken}))
+			},
+			handler:     &Handler{RestAPIID: "other", Config: aws.Config{Region: "eu-west-1"}},
+			expIdentity: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := tt.setup(httptest.NewRequest(http.MethodGet, "/", nil))
+
+			cfg, identity, err := resolver.configFor(r, tt.handler)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expIdentity, identity)
+			assert.Equal(t, "eu-west-1", cfg.Region)
+		})
+	}
+}
+
+func TestIdentityRuleValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		rule   IdentityRule
+		expErr string
+	}{
+		{
+			name:   "Missing name",
+			rule:   IdentityRule{Match: IdentityMatch{Principal: "ci"}, ProfileName: "ci"},
+			expErr: "identity rule without name",
+		},
+		{
+			name:   "Missing identity",
+			rule:   IdentityRule{Name: "ci", Match: IdentityMatch{Principal: "ci"}},
+			expErr: "identity rule ci requires profile_name or role_arn",
+		},
+		{
+			name:   "Missing match",
+			rule:   IdentityRule{Name: "ci", ProfileName: "ci"},
+			expErr: "identity rule ci requires at least one match condition",
+		},
+		{
+			name:   "Header without value",
+			rule:   IdentityRule{Name: "ci", Match: IdentityMatch{Header: "X-Team"}, ProfileName: "ci"},
+			expErr: "identity rule ci requires header and header_value together",
+		},
+		{
+			name: "Valid",
+			rule: IdentityRule{Name: "ci", Match: IdentityMatch{ClientCertCN: "runner"}, RoleARN: "arn:aws:iam::123456789012:role/ci"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.rule.validate()
+			if tt.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.expErr)
+			}
+		})
+	}
+}
+
+func TestIdentityResolverLoad(t *testing.T) {
+	t.Parallel()
+
+	rule := IdentityRule{Name: "ci", Match: IdentityMatch{Principal: "ci"}, RoleARN: "arn:aws:iam::123456789012:role/ci"}
+	resolver := newIdentityResolver([]IdentityRule{rule})
+
+	handler := &Handler{RestAPIID: "abc123", Config: aws.Config{Region: "eu-west-1"}}
+	cfg, err := resolver.load(rule, handler)
+	require.NoError(t, err)
+
+	cached, err := resolver.load(rule, &Handler{RestAPIID: "abc123", Config: aws.Config{Region: "eu-west-1"}})
+	require.NoError(t, err)
+	assert.Same(t, cfg.Credentials, cached.Credentials)
+
+	// Gateways sharing the Rest API ID with another profile or region have
+	// their own configuration
+	otherRegion, err := resolver.load(rule, &Handler{RestAPIID: "abc123", Config: aws.Config{Region: "us-east-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, "us-east-1", otherRegion.Region)
+	assert.NotSame(t, cfg.Credentials, otherRegion.Credentials)
+
+	otherProfile, err := resolver.load(rule, &Handler{RestAPIID: "abc123", ProfileName: "staging", Config: aws.Config{Region: "eu-west-1"}})
+	require.NoError(t, err)
+	assert.NotSame(t, cfg.Credentials, otherProfile.Credentials)
+
+	// Resetting the resolver loads the configurations again
+	resolver.reset()
+	reloaded, err := resolver.load(rule, handler)
+	require.NoError(t, err)
+	assert.NotSame(t, cfg.Credentials, reloaded.Credentials)
+}
+
+func TestIdentityRuleMatchesHeader(t *testing.T) {
+	t.Parallel()
+
+	rule := IdentityRule{Name: "payments", Match: IdentityMatch{Header: "X-Team-Key", HeaderValue: "s3cret"}}
+
+	for value, exp := range map[string]bool{"s3cret": true, "s3cre": false, "s3cret!": false, "": false} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Team-Key", value)
+		assert.Equal(t, exp, rule.matches(r, &Handler{}), value)
+	}
+}
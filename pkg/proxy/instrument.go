@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// adminRoute labels the metrics and spans of the admin requests.
+var adminRoute = &Handler{StagePath: AdminPathPrefix}
+
+// requestRoute is filled in with the route serving a request, found after its
+// metrics and span start.
+type requestRoute struct {
+	handler *Handler
+	span    trace.Span
+	// status is the status reported by the route, used when no response was
+	// written, like for dropped connections.
+	status int
+}
+
+type requestRouteKey struct{}
+
+// withInstrumentation records the metrics and server span of every request,
+// including the ones rejected before reaching a route.
+func withInstrumentation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestsInFlight.Inc()
+		defer requestsInFlight.Dec()
+
+		ctx, span := startServerSpan(r)
+		route := &requestRoute{span: span}
+		r = r.WithContext(context.WithValue(ctx, requestRouteKey{}, route))
+		rec := &responseRecorder{ResponseWriter: w}
+
+		defer func() {
+			status := route.responseStatus(rec)
+			observeRequest(route.handler, r.Method, status, time.Since(start))
+			endServerSpan(span, status)
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+func routeFromContext(ctx context.Context) *requestRoute {
+	route, _ := ctx.Value(requestRouteKey{}).(*requestRoute)
+	return route
+}
+
+// setRequestRoute records the handler serving the request.
+func setRequestRoute(r *http.Request, handler *Handler) {
+	if route := routeFromContext(r.Context()); route != nil {
+		route.handler = handler
+		setSpanRoute(route.span, r.Method, handler)
+	}
+}
+
+// setRequestStatus records the status of the request as seen by its route.
+func setRequestStatus(r *http.Request, status int) {
+	if route := routeFromContext(r.Context()); route != nil {
+		route.status = status
+	}
+}
+
+// responseStatus returns the status of the response written to rec, or the
+// one reported by the route when nothing was written.
+func (route *requestRoute) responseStatus(rec *responseRecorder) int {
+	if route != nil && rec.status == 0 && route.status != 0 {
+		return route.status
+	}
+	return rec.statusCode()
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// InvokeResult is the response of a request sent with Invoke.
+type InvokeResult struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+	// Log is the redacted execution log of API Gateway, empty for mocks and
+	// routes sent to an upstream.
+	Log        string  `json:"log,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+type executionLogKey struct{}
+
+func setExecutionLog(ctx context.Context, log string) {
+	if executionLog, ok := ctx.Value(executionLogKey{}).(*string); ok {
+		*executionLog = log
+	}
+}
+
+// Invoke sends a single request through the routes served on every listen
+// address, the proxy ones first, without authenticating it, and returns the
+// response.
+func (p *Proxy) Invoke(r *http.Request) *InvokeResult {
+	var executionLog string
+	r = r.WithContext(context.WithValue(r.Context(), executionLogKey{}, &executionLog))
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	withRequestID(withInstrumentation(p.routeHandler(p.invokeListenAddress(r)))).ServeHTTP(rec, r)
+
+	return &InvokeResult{
+		Status:     rec.Code,
+		Headers:    rec.Header(),
+		Body:       rec.Body.String(),
+		Log:        executionLog,
+		DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+}
+
+// invokeListenAddress returns the listen address of the first route table
+// with a route for the request, searching the proxy one first.
+func (p *Proxy) invokeListenAddress(r *http.Request) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	addresses := lo.Keys(p.routes)
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		if findHandler(p.routes[address], getPath(r.URL), r.Method) != nil {
+			return address
+		}
+	}
+	return ""
+}
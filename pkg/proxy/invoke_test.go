@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyInvoke(t *testing.T) {
+	t.Parallel()
+
+	p := NewProxy(nil, &Config{
+		Mocks: []MockConfig{{Path: "/dev/users/{id}", Methods: []string{"GET"}, Body: `{"id": "{{ .Path.id }}"}`}},
+	})
+	require.NoError(t, p.Reload())
+
+	result := p.Invoke(httptest.NewRequest(http.MethodGet, "/dev/users/42", nil))
+	assert.Equal(t, http.StatusOK, result.Status)
+	assert.JSONEq(t, `{"id": "42"}`, result.Body)
+	assert.NotEmpty(t, result.Headers.Get(RequestIDHeader))
+	assert.Empty(t, result.Log)
+
+	result = p.Invoke(httptest.NewRequest(http.MethodGet, "/dev/orders", nil))
+	assert.Equal(t, http.StatusInternalServerError, result.Status)
+	assert.Contains(t, result.Body, "Handler not found")
+}
+
+func TestProxyInvokeListenAddress(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/nonexistent")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	gw := GatewayConfig{RestAPIID: "abc123", Region: "eu-west-1", StageName: "dev", ListenAddress: "127.0.0.1:9001"}
+	cache := NewRouteCache(afero.NewMemMapFs(), "/cache")
+	require.NoError(t, cache.store(gw, &gatewayRoutes{
+		Region:    "eu-west-1",
+		StageName: "dev",
+		Resources: []gatewayResource{{Path: "/orders", ResourceID: "res", Methods: []string{"GET"}}},
+		AccountID: "123456789012",
+		Identity:  "arn:aws:iam::123456789012:user/dev",
+	}))
+
+	p := NewProxy(nil, &Config{
+		Gateways: []GatewayConfig{gw},
+		Mocks:    []MockConfig{{Path: "/health", Status: http.StatusNoContent, ListenAddress: "127.0.0.1:9002"}},
+	})
+	p.UseRouteCache(cache, true)
+	require.NoError(t, p.Reload())
+
+	result := p.Invoke(httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusNoContent, result.Status)
+
+	// The gateway route is found, the call fails without credentials
+	result = p.Invoke(httptest.NewRequest(http.MethodGet, "/orders", nil))
+	assert.NotContains(t, result.Body, "Handler not found")
+	assert.Contains(t, result.Body, "Error calling API Gateway")
+}
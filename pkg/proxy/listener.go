@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// UnixSocketScheme prefixes listen addresses of Unix domain sockets, like
+// `unix:///tmp/agbridge.sock`.
+const UnixSocketScheme = "unix://"
+
+// ValidateListenAddress checks addr is a TCP `host:port` or a Unix domain
+// socket address.
+func ValidateListenAddress(addr string) error {
+	if path, ok := strings.CutPrefix(addr, UnixSocketScheme); ok {
+		if path == "" {
+			return fmt.Errorf("address %s: missing socket path", addr)
+		}
+		return nil
+	}
+
+	_, _, err := net.SplitHostPort(addr)
+	return err
+}
+
+// overlappingAddresses reports whether listening on a and b conflicts: they
+// are the same socket, or TCP addresses with the same port where the hosts
+// are equal or either listens on every interface. Port 0 picks a free port and
+// never conflicts.
+func overlappingAddresses(a, b string) bool {
+	if strings.HasPrefix(a, UnixSocketScheme) || strings.HasPrefix(b, UnixSocketScheme) {
+		return a == b
+	}
+
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil || portA != portB || portA == "0" {
+		return false
+	}
+
+	unspecified := func(host string) bool {
+		ip := net.ParseIP(host)
+		return host == "" || (ip != nil && ip.IsUnspecified())
+	}
+	return hostA == hostB || unspecified(hostA) || unspecified(hostB)
+}
+
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, UnixSocketScheme)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	// Remove the socket left behind by a previous run that didn't stop
+	// cleanly, but never the socket of a running one
+	if info, err := os.Stat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("listen unix %s: address already in use", path)
+		}
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, fmt.Errorf("listen unix %s: address already in use: %w", path, err)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return net.Listen("unix", path)
+}
+
+// serverURL returns the base URL of a server listening on a TCP address,
+// using the loopback address when it listens on every interface. It is empty
+// for Unix domain sockets.
+func serverURL(server *http.Server) string {
+	if strings.HasPrefix(server.Addr, UnixSocketScheme) {
+		return ""
+	}
+
+	host, port, err := net.SplitHostPort(server.Addr)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	scheme := "http"
+	if server.TLSConfig != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
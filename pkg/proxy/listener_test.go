@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatewayURLs(t *testing.T) {
+	t.Parallel()
+
+	proxy := NewProxy([]string{"127.0.0.1:0"}, &Config{
+		Gateways: []GatewayConfig{
+			{RestAPIID: "staged", StageName: "dev"},
+			{RestAPIID: "unstaged"},
+			{RestAPIID: "own", StageName: "prod", ListenAddress: "127.0.0.1:0"},
+			{RestAPIID: "prefixed", StageName: "dev", PathPrefix: "/orders"},
+		},
+	})
+	require.NoError(t, proxy.Listen())
+	t.Cleanup(func() { _ = proxy.Shutdown(t.Context()) })
+
+	addrs := proxy.Addrs()
+	require.Len(t, addrs, 2)
+	for _, addr := range addrs {
+		_, port, err := net.SplitHostPort(addr)
+		require.NoError(t, err)
+		assert.NotEqual(t, "0", port)
+	}
+
+	assert.Equal(t, "http://"+addrs[0], proxy.URL())
+	assert.Equal(t, []string{"http://" + addrs[0] + "/dev", "http://" + addrs[0], "http://" + addrs[1], "http://" + addrs[0] + "/orders/dev"}, proxy.GatewayURLs())
+}
+
+func TestConfigValidateListenAddresses(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		config          *Config
+		listenAddresses []string
+		expErr          string
+	}{
+		{
+			name:            "Distinct ports",
+			config:          &Config{Gateways: []GatewayConfig{{RestAPIID: "abc", ListenAddress: ":8081"}}},
+			listenAddresses: []string{":8080"},
+		},
+		{
+			name:            "Same gateway address",
+			config:          &Config{Gateways: []GatewayConfig{{RestAPIID: "abc", ListenAddress: ":8080"}}},
+			listenAddresses: []string{"127.0.0.1:9090", ":8080"},
+			expErr:          "listen address :8080 of the configuration overlaps the proxy listen address :8080, use another port or remove it from --listen-address",
+		},
+		{
+			name:            "Every interface",
+			config:          &Config{Mocks: []MockConfig{{Path: "/health", ListenAddress: "127.0.0.1:8080"}}},
+			listenAddresses: []string{"0.0.0.0:8080"},
+			expErr:          "listen address 127.0.0.1:8080 of the configuration overlaps the proxy listen address 0.0.0.0:8080, use another port or remove it from --listen-address",
+		},
+		{
+			name:            "Distinct hosts",
+			config:          &Config{Routes: []RouteConfig{{Path: "/users", Upstream: "http://localhost:3000", ListenAddress: "127.0.0.2:8080"}}},
+			listenAddresses: []string{"127.0.0.1:8080"},
+		},
+		{
+			name:            "Free ports",
+			config:          &Config{Gateways: []GatewayConfig{{RestAPIID: "abc", ListenAddress: "127.0.0.1:0"}}},
+			listenAddresses: []string{"127.0.0.1:0"},
+		},
+		{
+			name:            "Same socket",
+			config:          &Config{Gateways: []GatewayConfig{{RestAPIID: "abc", ListenAddress: "unix:///tmp/agbridge.sock"}}},
+			listenAddresses: []string{"unix:///tmp/agbridge.sock"},
+			expErr:          "listen address unix:///tmp/agbridge.sock of the configuration overlaps the proxy listen address unix:///tmp/agbridge.sock, use another port or remove it from --listen-address",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.config.ValidateListenAddresses(tt.listenAddresses)
+			if tt.expErr != "" {
+				require.EqualError(t, err, tt.expErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	t.Parallel()
+
+	// Socket paths are limited to around 100 characters
+	dir, err := os.MkdirTemp("", "agbridge")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "agbridge.sock")
+
+	// A socket left behind by a run that didn't stop cleanly is replaced
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	listener, err := listen(UnixSocketScheme + path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	// The socket of a running instance is kept
+	_, err = listen(UnixSocketScheme + path)
+	require.EqualError(t, err, "listen unix "+path+": address already in use")
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+}
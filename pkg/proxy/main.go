@@ -2,37 +2,430 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
 	"net/http"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oscarbc96/agbridge/pkg/auth"
 )
 
+type GatewayStatus struct {
+	RestAPIID string    `json:"rest_api_id"`
+	StageName string    `json:"stage_name,omitempty"`
+	Ready     bool      `json:"ready"`
+	Routes    int       `json:"routes"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
 type Proxy struct {
-	server *http.Server
+	servers   []*http.Server
+	listeners []net.Listener
+	// gatewayServers are the servers of the gateway listen addresses.
+	gatewayServers map[string]*http.Server
+	config         *Config
+	authenticator  *auth.Authenticator
+	identities     *identityResolver
+	faults         *faultInjector
+	cache          *responseCache
+	preflight      preflightClient
+	// resolve resolves the routes of a gateway from API Gateway.
+	resolve func(gw GatewayConfig) ([]Handler, error)
+
+	// Requests without a route wait up to loadWait while the gateways of their
+	// listen address are still loading.
+	loadWait time.Duration
+
+	// routeCache stores the resolved routes, offline only reads them.
+	routeCache *RouteCache
+	offline    bool
+
+	mu sync.RWMutex
+	// routes holds a route table per gateway listen address, the routes of
+	// gateways without one are served on every proxy listen address.
+	routes   map[string]map[*regexp.Regexp]Handler
+	gateways []GatewayStatus
+	// handlers are the last routes resolved per gateway, indexed like
+	// config.Gateways, and local those of route overrides and mocks.
+	handlers    [][]Handler
+	local       []Handler
+	localLoaded bool
+	// changed is closed and replaced whenever the route tables change.
+	changed chan struct{}
 }
 
-func NewProxy(listenAddress string, handlerMapping map[*regexp.Regexp]Handler) *Proxy {
-	handler := func(w http.ResponseWriter, r *http.Request) {
-		defaultHandleRequest(w, r, handlerMapping)
+func NewProxy(listenAddresses []string, config *Config) *Proxy {
+	proxy := &Proxy{
+		config:         config,
+		identities:     newIdentityResolver(config.Identities),
+		faults:         newFaultInjector(config.Faults),
+		cache:          newResponseCache(config.Cache),
+		preflight:      awsPreflightClient{},
+		resolve:        GatewayConfig.resolve,
+		routes:         make(map[string]map[*regexp.Regexp]Handler),
+		gateways:       make([]GatewayStatus, len(config.Gateways)),
+		gatewayServers: make(map[string]*http.Server),
+		handlers:       make([][]Handler, len(config.Gateways)),
+		changed:        make(chan struct{}),
 	}
 
-	proxy := &Proxy{
-		server: &http.Server{
-			Addr:    listenAddress,
-			Handler: http.HandlerFunc(handler),
-		},
+	for i, gw := range config.Gateways {
+		proxy.gateways[i] = GatewayStatus{RestAPIID: gw.RestAPIID, StageName: gw.StageName}
+	}
+
+	for _, addr := range listenAddresses {
+		proxy.servers = append(proxy.servers, proxy.newServer(addr, ""))
+	}
+
+	for _, addr := range config.listenAddresses() {
+		server := proxy.newServer(addr, addr)
+		proxy.servers = append(proxy.servers, server)
+		proxy.gatewayServers[addr] = server
 	}
 
 	return proxy
 }
 
+// newServer returns a server listening on addr that proxies requests using
+// the route table of the gateways with the given listen address.
+func (p *Proxy) newServer(addr, gatewayListenAddress string) *http.Server {
+	admin := http.StripPrefix(AdminPathPrefix, p.adminHandler())
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, AdminPathPrefix+"/") {
+			setRequestRoute(r, adminRoute)
+			admin.ServeHTTP(w, r)
+			return
+		}
+		p.routeHandler(gatewayListenAddress).ServeHTTP(w, r)
+	}
+
+	return &http.Server{
+		Addr:    addr,
+		Handler: withRequestID(withInstrumentation(withAccessLog(p.withAuth(http.HandlerFunc(handler))))),
+	}
+}
+
+// routeHandler proxies requests using the route table of the gateways with
+// the given listen address.
+func (p *Proxy) routeHandler(gatewayListenAddress string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routes, ok := p.waitRoutes(r, gatewayListenAddress)
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, r, http.StatusServiceUnavailable, nil, "Routes are still loading")
+			return
+		}
+		defaultHandleRequest(w, r, routes, p.identities, p.faults, p.cache)
+	})
+}
+
+// UseRouteCache stores the routes resolved by Reload in the cache. When
+// offline, Reload reads them from the cache instead of API Gateway.
+func (p *Proxy) UseRouteCache(cache *RouteCache, offline bool) {
+	p.routeCache = cache
+	p.offline = offline
+}
+
+// Reload resolves the resources of every gateway again, serving the routes of
+// each gateway as soon as they resolve. Gateways failing keep their current
+// routes and report the error in their status. The AWS configurations of the
+// identity rules are loaded again on their next use.
+func (p *Proxy) Reload() error {
+	p.identities.reset()
+
+	switch {
+	case p.routeCache == nil:
+		return p.reload(p.resolve, p.gatewayResolved)
+	case p.offline:
+		return p.reload(p.routeCache.load, p.gatewayResolved)
+	default:
+		return p.reload(p.routeCache.resolve, p.gatewayResolved)
+	}
+}
+
+// LoadCachedRoutes swaps the route tables with the routes in the route cache,
+// failing when a gateway has none. Reload revalidates them.
+func (p *Proxy) LoadCachedRoutes() error {
+	if p.routeCache == nil {
+		return errors.New("no route cache")
+	}
+	// Gateways without cached routes are still loading, not failing
+	return p.reload(p.routeCache.load, func(i int, handlers []Handler, err error) error {
+		if err != nil {
+			return err
+		}
+		return p.gatewayResolved(i, handlers, nil)
+	})
+}
+
+func (p *Proxy) reload(resolve func(gw GatewayConfig) ([]Handler, error), resolved func(i int, handlers []Handler, err error) error) error {
+	local, err := p.config.localHandlers()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.local = local
+	err = p.swapRoutes()
+	p.localLoaded = err == nil
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Every gateway is served as soon as it resolves, gateways failing keep
+	// their previous routes
+	_, errs := p.config.resolveGateways(resolve, resolved)
+
+	return errors.Join(errs...)
+}
+
+// gatewayResolved records the status of the gateway and swaps the route
+// tables with its routes. It returns the error of the gateway, or the error
+// building the route tables with its routes.
+func (p *Proxy) gatewayResolved(i int, handlers []Handler, err error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		previous := p.handlers[i]
+		p.handlers[i] = handlers
+		if err = p.swapRoutes(); err != nil {
+			p.handlers[i] = previous
+		}
+	}
+
+	gw := &p.gateways[i]
+	observeRouteRefresh(gw.RestAPIID, err)
+	gw.UpdatedAt = time.Now()
+	if err != nil {
+		observeAWSError(gw.RestAPIID, err)
+		gw.Error = err.Error()
+		// Let the requests waiting for the gateway go on
+		p.notifyChanged()
+		return err
+	}
+
+	gw.Error = ""
+	gw.Ready = true
+	gw.Routes = len(handlers)
+	return nil
+}
+
+// swapRoutes builds the route tables from the routes of every gateway and
+// the local ones, and swaps them. It must be called with the lock held.
+func (p *Proxy) swapRoutes() error {
+	routes, err := buildRouteTables(append(append([][]Handler(nil), p.handlers...), p.local))
+	if err != nil {
+		return fmt.Errorf("failed to build route table: %w", err)
+	}
+	p.routes = routes
+	p.notifyChanged()
+	return nil
+}
+
+// notifyChanged wakes up the requests waiting for routes. It must be called
+// with the lock held.
+func (p *Proxy) notifyChanged() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// SetLoadWait sets how long requests received before the routes are loaded
+// wait for them, before being answered with 503 Service Unavailable.
+func (p *Proxy) SetLoadWait(wait time.Duration) {
+	p.loadWait = wait
+}
+
+// waitRoutes returns the route table of the listen address once it has a
+// route for the request, or once every gateway served on the address is
+// done loading. It waits up to the load wait, and reports false when it ends
+// first.
+func (p *Proxy) waitRoutes(r *http.Request, gatewayListenAddress string) (map[*regexp.Regexp]Handler, bool) {
+	var timeout <-chan time.Time
+	for {
+		p.mu.RLock()
+		routes, changed := p.routes[gatewayListenAddress], p.changed
+		ready := p.localLoaded && !p.loading(gatewayListenAddress)
+		p.mu.RUnlock()
+
+		if ready || findHandler(routes, getPath(r.URL), r.Method) != nil {
+			return routes, true
+		}
+
+		if p.loadWait <= 0 {
+			return nil, false
+		}
+		if timeout == nil {
+			timer := time.NewTimer(p.loadWait)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+			return nil, false
+		case <-r.Context().Done():
+			return nil, false
+		}
+	}
+}
+
+// loading reports whether a gateway served on the listen address has neither
+// loaded nor failed yet. It must be called with the lock held.
+func (p *Proxy) loading(gatewayListenAddress string) bool {
+	for i, gw := range p.config.Gateways {
+		if gw.ListenAddress == gatewayListenAddress && !p.gateways[i].Ready && p.gateways[i].Error == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proxy) routeTable(gatewayListenAddress string) map[*regexp.Regexp]Handler {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.routes[gatewayListenAddress]
+}
+
+// HandlerMapping returns the routes served on every listen address.
+func (p *Proxy) HandlerMapping() map[*regexp.Regexp]Handler {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	handlerMapping := make(map[*regexp.Regexp]Handler)
+	for _, routes := range p.routes {
+		maps.Copy(handlerMapping, routes)
+	}
+	return handlerMapping
+}
+
+// Gateways returns a snapshot of the status of every configured gateway.
+func (p *Proxy) Gateways() []GatewayStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]GatewayStatus(nil), p.gateways...)
+}
+
+// UseTLS makes the proxy serve HTTPS with the given configuration.
+func (p *Proxy) UseTLS(config *tls.Config) {
+	for _, server := range p.servers {
+		server.TLSConfig = config
+	}
+}
+
+// Listen binds every listen address. Port 0 of TCP addresses is replaced by
+// the port chosen by the system, reported by Addrs.
+func (p *Proxy) Listen() error {
+	listeners := make([]net.Listener, 0, len(p.servers))
+	for _, server := range p.servers {
+		listener, err := listen(server.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %w", server.Addr, err)
+		}
+		if _, ok := listener.Addr().(*net.TCPAddr); ok {
+			server.Addr = listener.Addr().String()
+		}
+		listeners = append(listeners, listener)
+	}
+
+	p.listeners = listeners
+	return nil
+}
+
+// Start serves requests until one of the servers stops, returning its error.
+// It listens on every address first unless Listen was called.
 func (p *Proxy) Start() error {
-	return p.server.ListenAndServe()
+	if p.listeners == nil {
+		if err := p.Listen(); err != nil {
+			return err
+		}
+	}
+
+	errCh := make(chan error, len(p.servers))
+	for i, server := range p.servers {
+		go func(server *http.Server, listener net.Listener) {
+			if server.TLSConfig != nil {
+				errCh <- server.ServeTLS(listener, "", "")
+				return
+			}
+			errCh <- server.Serve(listener)
+		}(server, p.listeners[i])
+	}
+
+	return <-errCh
 }
 
 func (p *Proxy) Shutdown(ctx context.Context) error {
-	return p.server.Shutdown(ctx)
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(p.servers))
+	)
+
+	for i, server := range p.servers {
+		wg.Add(1)
+		go func(i int, server *http.Server) {
+			defer wg.Done()
+			errs[i] = server.Shutdown(ctx)
+		}(i, server)
+	}
+
+	wg.Wait()
+
+	// Close the listeners bound by Listen that weren't served
+	for _, listener := range p.listeners {
+		_ = listener.Close()
+	}
+
+	return errors.Join(errs...)
 }
 
-func (p *Proxy) Addr() string {
-	return p.server.Addr
+// Addrs returns the addresses the proxy listens on.
+func (p *Proxy) Addrs() []string {
+	addrs := make([]string, 0, len(p.servers))
+	for _, server := range p.servers {
+		addrs = append(addrs, server.Addr)
+	}
+	return addrs
+}
+
+// URL returns the base URL of the first TCP address the proxy listens on,
+// besides the gateway listen addresses. It is empty when there is none.
+func (p *Proxy) URL() string {
+	for _, server := range p.servers[:len(p.servers)-len(p.gatewayServers)] {
+		if url := serverURL(server); url != "" {
+			return url
+		}
+	}
+	return ""
+}
+
+// GatewayURLs returns the base URL of every gateway, indexed like Gateways:
+// its own listen address, or the proxy URL with the stage prefix, after the
+// path prefix of the gateway.
+func (p *Proxy) GatewayURLs() []string {
+	base := p.URL()
+	urls := make([]string, len(p.config.Gateways))
+	for i, gw := range p.config.Gateways {
+		switch {
+		case gw.ListenAddress != "":
+			urls[i] = serverURL(p.gatewayServers[gw.ListenAddress]) + gw.PathPrefix
+		case base != "" && gw.StageName != "":
+			urls[i] = base + gw.PathPrefix + "/" + gw.StageName
+		case base != "":
+			urls[i] = base + gw.PathPrefix
+		}
+	}
+	return urls
 }
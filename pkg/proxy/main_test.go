@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteHandlerBeforeRoutesLoad(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Unavailable after the load wait", func(t *testing.T) {
+		t.Parallel()
+
+		proxy := NewProxy(nil, &Config{})
+		proxy.SetLoadWait(10 * time.Millisecond)
+
+		rec := httptest.NewRecorder()
+		proxy.routeHandler("").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dev/users", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	})
+
+	t.Run("Held until the routes load", func(t *testing.T) {
+		t.Parallel()
+
+		proxy := NewProxy(nil, &Config{Mocks: []MockConfig{{Path: "/health", Status: http.StatusNoContent}}})
+		proxy.SetLoadWait(time.Minute)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			assert.NoError(t, proxy.Reload())
+		}()
+
+		rec := httptest.NewRecorder()
+		proxy.routeHandler("").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	})
+}
+
+func TestRouteHandlerPerGateway(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(upstream.Close)
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	proxy := NewProxy(nil, &Config{Gateways: []GatewayConfig{{RestAPIID: "fast"}, {RestAPIID: "slow"}, {RestAPIID: "broken"}}})
+	proxy.SetLoadWait(time.Minute)
+
+	release := make(chan struct{})
+	resolve := func(gw GatewayConfig) ([]Handler, error) {
+		switch gw.RestAPIID {
+		case "slow":
+			<-release
+		case "broken":
+			return nil, errors.New("access denied")
+		}
+		path := "/" + gw.RestAPIID
+		return []Handler{{StagePath: path, Path: path, RestAPIID: gw.RestAPIID, Methods: []string{http.MethodGet}, Upstream: upstreamURL}}, nil
+	}
+
+	reloaded := make(chan error, 1)
+	go func() { reloaded <- proxy.reload(resolve, proxy.gatewayResolved) }()
+
+	serve := func(path string) int {
+		ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+		defer cancel()
+		rec := httptest.NewRecorder()
+		proxy.routeHandler("").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+		return rec.Code
+	}
+
+	// The fast gateway is served while the slow one is still loading
+	assert.Equal(t, http.StatusNoContent, serve("/fast"))
+
+	slow := make(chan int, 1)
+	go func() { slow <- serve("/slow") }()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	assert.Equal(t, http.StatusNoContent, <-slow)
+
+	require.ErrorContains(t, <-reloaded, "access denied")
+
+	// Once every gateway is done, requests without a route are not held
+	assert.Equal(t, http.StatusInternalServerError, serve("/broken"))
+
+	rec := httptest.NewRecorder()
+	proxy.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"error":"access denied"`)
+}
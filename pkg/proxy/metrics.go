@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/apigateway"
+	"github.com/aws/smithy-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/samber/lo"
+)
+
+const metricsNamespace = "agbridge"
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	requestsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_total",
+		Help:      "Number of proxied requests.",
+	}, []string{"gateway", "route", "method", "status"})
+
+	requestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Latency of proxied requests.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"gateway", "route", "method", "status"})
+
+	requestsInFlight = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_in_flight",
+		Help:      "Number of requests currently being proxied.",
+	})
+
+	awsErrorsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "aws_errors_total",
+		Help:      "Number of failed AWS SDK calls by error code.",
+	}, []string{"gateway", "code"})
+
+	awsThrottlesTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "aws_throttles_total",
+		Help:      "Number of AWS SDK attempts rejected by throttling.",
+	}, []string{"gateway"})
+
+	awsRetriesTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "aws_retries_total",
+		Help:      "Number of AWS SDK attempts retried.",
+	}, []string{"gateway"})
+
+	cacheRequestsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_requests_total",
+		Help:      "Number of cacheable requests by result.",
+	}, []string{"result"})
+
+	routeRefreshTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "route_refresh_total",
+		Help:      "Number of route table refreshes by gateway and result.",
+	}, []string{"gateway", "result"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+func observeRequest(handler *Handler, method string, status int, elapsed time.Duration) {
+	var gateway, route string
+	if handler != nil {
+		gateway, route = handler.RestAPIID, handler.StagePath
+		switch {
+		case handler.Mock != nil:
+			gateway = "mock"
+		case handler.Upstream != nil:
+			gateway = handler.Upstream.Redacted()
+		}
+	}
+
+	labels := prometheus.Labels{
+		"gateway": gateway,
+		"route":   route,
+		"method":  method,
+		"status":  strconv.Itoa(status),
+	}
+	requestsTotal.With(labels).Inc()
+	requestDuration.With(labels).Observe(elapsed.Seconds())
+}
+
+func observeCache(hit bool) {
+	cacheRequestsTotal.WithLabelValues(lo.Ternary(hit, "hit", "miss")).Inc()
+}
+
+func observeAWSError(gateway string, err error) {
+	code := "Unknown"
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code = apiErr.ErrorCode()
+	}
+	awsErrorsTotal.WithLabelValues(gateway, code).Inc()
+}
+
+func observeRouteRefresh(gateway string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	routeRefreshTotal.WithLabelValues(gateway, result).Inc()
+}
+
+// instrumentedRetryer counts throttled and retried attempts of the wrapped
+// retryer.
+type instrumentedRetryer struct {
+	aws.Retryer
+
+	gateway string
+}
+
+func withRetryMetrics(gateway string) func(*apigateway.Options) {
+	return func(o *apigateway.Options) {
+		o.Retryer = instrumentedRetryer{Retryer: o.Retryer, gateway: gateway}
+	}
+}
+
+func (r instrumentedRetryer) IsErrorRetryable(err error) bool {
+	if retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary {
+		awsThrottlesTotal.WithLabelValues(r.gateway).Inc()
+	}
+	return r.Retryer.IsErrorRetryable(err)
+}
+
+func (r instrumentedRetryer) RetryDelay(attempt int, err error) (time.Duration, error) {
+	awsRetriesTotal.WithLabelValues(r.gateway).Inc()
+	return r.Retryer.RetryDelay(attempt, err)
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// The metrics are global, every test uses its own gateway labels.
+
+// serveInstrumented handles r with the routes of handlerMapping, recording its
+// metrics and span like the proxy servers.
+func serveInstrumented(w http.ResponseWriter, r *http.Request, handlerMapping map[*regexp.Regexp]Handler) {
+	withInstrumentation(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultHandleRequest(w, r, handlerMapping, newIdentityResolver(nil), nil, nil)
+	})).ServeHTTP(w, r)
+}
+
+func TestObserveRequest(t *testing.T) {
+	t.Parallel()
+
+	upstream, _ := url.Parse("http://observe-request.local")
+
+	tests := []struct {
+		name       string
+		handler    *Handler
+		expGateway string
+		expRoute   string
+	}{
+		{name: "No handler", handler: nil, expGateway: "", expRoute: ""},
+		{name: "API Gateway", handler: &Handler{RestAPIID: "observe-request", StagePath: "/dev/users"}, expGateway: "observe-request", expRoute: "/dev/users"},
+		{name: "Mock", handler: &Handler{StagePath: "/observe-request/mock", Mock: &MockConfig{}}, expGateway: "mock", expRoute: "/observe-request/mock"},
+		{name: "Upstream", handler: &Handler{StagePath: "/users", Upstream: upstream}, expGateway: "http://observe-request.local", expRoute: "/users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			counter := requestsTotal.WithLabelValues(tt.expGateway, tt.expRoute, "PATCH", "418")
+			before := testutil.ToFloat64(counter)
+			observeRequest(tt.handler, "PATCH", http.StatusTeapot, time.Second)
+			assert.InDelta(t, before+1, testutil.ToFloat64(counter), 0)
+		})
+	}
+}
+
+func TestInstrumentation(t *testing.T) {
+	t.Parallel()
+
+	p := NewProxy(nil, &Config{Gateways: []GatewayConfig{{RestAPIID: "instrumentation"}}})
+	server := p.newServer(":0", "")
+
+	// Requests rejected before reaching a route are counted too
+	loading := requestsTotal.WithLabelValues("", "", "PROPFIND", "503")
+	before := testutil.ToFloat64(loading)
+	rec := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, httptest.NewRequest("PROPFIND", "/dev/users", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.InDelta(t, before+1, testutil.ToFloat64(loading), 0)
+
+	admin := requestsTotal.WithLabelValues("", AdminPathPrefix, http.MethodGet, "200")
+	before = testutil.ToFloat64(admin)
+	rec = httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, AdminPathPrefix+"/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.InDelta(t, before+1, testutil.ToFloat64(admin), 0)
+}
+
+func TestObserveAWSError(t *testing.T) {
+	t.Parallel()
+
+	observeAWSError("observe-aws-error", &smithy.GenericAPIError{Code: "AccessDeniedException"})
+	observeAWSError("observe-aws-error", &smithy.GenericAPIError{Code: "AccessDeniedException"})
+	observeAWSError("observe-aws-error", errors.New("connection refused"))
+
+	assert.InDelta(t, 2, testutil.ToFloat64(awsErrorsTotal.WithLabelValues("observe-aws-error", "AccessDeniedException")), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(awsErrorsTotal.WithLabelValues("observe-aws-error", "Unknown")), 0)
+}
+
+func TestObserveRouteRefresh(t *testing.T) {
+	t.Parallel()
+
+	observeRouteRefresh("observe-route-refresh", nil)
+	observeRouteRefresh("observe-route-refresh", errors.New("throttled"))
+	observeRouteRefresh("observe-route-refresh", errors.New("throttled"))
+
+	assert.InDelta(t, 1, testutil.ToFloat64(routeRefreshTotal.WithLabelValues("observe-route-refresh", "success")), 0)
+	assert.InDelta(t, 2, testutil.ToFloat64(routeRefreshTotal.WithLabelValues("observe-route-refresh", "failure")), 0)
+}
+
+func TestInstrumentedRetryer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		gateway     string
+		status      int
+		errorType   string
+		expThrottle float64
+		expRetries  float64
+		expCode     string
+	}{
+		{name: "Throttled", gateway: "retryer-throttled", status: http.StatusTooManyRequests, errorType: "TooManyRequestsException", expThrottle: 2, expRetries: 1, expCode: "TooManyRequestsException"},
+		{name: "Server error", gateway: "retryer-server-error", status: http.StatusServiceUnavailable, errorType: "ServiceUnavailableException", expThrottle: 0, expRetries: 1, expCode: "ServiceUnavailableException"},
+		{name: "Not retried", gateway: "retryer-denied", status: http.StatusForbidden, errorType: "AccessDeniedException", expThrottle: 0, expRetries: 0, expCode: "AccessDeniedException"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			awsCfg := newFakeAPIGateway(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("X-Amzn-Errortype", tt.errorType)
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(`{"message": "failed"}`))
+			})
+
+			rec := httptest.NewRecorder()
+			serveInstrumented(rec, httptest.NewRequest(http.MethodPost, "/dev/users", nil), testInvokeMapping(awsCfg, tt.gateway))
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+			assert.InDelta(t, tt.expThrottle, testutil.ToFloat64(awsThrottlesTotal.WithLabelValues(tt.gateway)), 0)
+			assert.InDelta(t, tt.expRetries, testutil.ToFloat64(awsRetriesTotal.WithLabelValues(tt.gateway)), 0)
+			assert.InDelta(t, 1, testutil.ToFloat64(awsErrorsTotal.WithLabelValues(tt.gateway, tt.expCode)), 0)
+			assert.InDelta(t, 1, testutil.ToFloat64(requestsTotal.WithLabelValues(tt.gateway, "/dev/users", http.MethodPost, "500")), 0)
+		})
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	t.Parallel()
+
+	observeCache(true)
+	observeRequest(&Handler{RestAPIID: "metrics-handler", StagePath: "/dev/users"}, http.MethodGet, http.StatusOK, time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `agbridge_requests_total{gateway="metrics-handler",method="GET",route="/dev/users",status="200"} 1`)
+	assert.Contains(t, rec.Body.String(), `agbridge_request_duration_seconds_bucket{gateway="metrics-handler",method="GET",route="/dev/users",status="200",le="0.05"} 1`)
+	assert.Contains(t, rec.Body.String(), `agbridge_cache_requests_total{result="hit"}`)
+	assert.Contains(t, rec.Body.String(), "agbridge_requests_in_flight")
+	assert.Contains(t, rec.Body.String(), "go_goroutines")
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"text/template"
+
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/spf13/afero"
+)
+
+// MockConfig answers the requests matching a path, and optionally a set of
+// methods, with a static or templated response instead of API Gateway.
+type MockConfig struct {
+	// Path is an API Gateway path template, like `/users/{id}`.
+	Path string `yaml:"path" json:"path"`
+	// Methods defaults to any method.
+	Methods []string `yaml:"methods" json:"methods,omitempty"`
+	// Status defaults to 200.
+	Status  int               `yaml:"status" json:"status,omitempty"`
+	Headers map[string]string `yaml:"headers" json:"headers,omitempty"`
+	// Body is a Go template rendered with the mockRequest, like
+	// `{"id": "{{ .Path.id }}", "page": "{{ .Query.Get "page" }}"}`.
+	Body string `yaml:"body" json:"body,omitempty"`
+	// BodyFile is read instead of Body, relative to the configuration file.
+	BodyFile      string `yaml:"body_file" json:"body_file,omitempty"`
+	ListenAddress string `yaml:"listen_address" json:"listen_address,omitempty"`
+
+	body   *template.Template
+	params *regexp.Regexp
+}
+
+// mockRequest is the data available to mock body templates.
+type mockRequest struct {
+	Method  string
+	Path    map[string]string
+	Query   url.Values
+	Headers http.Header
+	Body    string
+}
+
+// load validates the mock and parses its body, reading BodyFile from dir.
+func (m *MockConfig) load(fs afero.Fs, dir string) error {
+	if len(m.Path) == 0 || m.Path[0] != '/' {
+		return fmt.Errorf("mock path %q must start with /", m.Path)
+	}
+	if m.Status != 0 && (m.Status < 100 || m.Status > 599) {
+		return fmt.Errorf("invalid status %d for mock %s", m.Status, m.Path)
+	}
+	if m.Body != "" && m.BodyFile != "" {
+		return fmt.Errorf("mock %s can't have both body and body_file", m.Path)
+	}
+	if m.ListenAddress != "" {
+		if err := ValidateListenAddress(m.ListenAddress); err != nil {
+			return fmt.Errorf("invalid listen address format for mock %s: %w", m.Path, err)
+		}
+	}
+
+	body := m.Body
+	if m.BodyFile != "" {
+		filename := m.BodyFile
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(dir, filename)
+		}
+		content, err := afero.ReadFile(fs, filename)
+		if err != nil {
+			return fmt.Errorf("failed to read body file for mock %s: %w", m.Path, err)
+		}
+		body = string(content)
+	}
+
+	return m.parse(body)
+}
+
+func (m *MockConfig) parse(body string) error {
+	tmpl, err := template.New(m.Path).Option("missingkey=zero").Parse(body)
+	if err != nil {
+		return fmt.Errorf("invalid body template for mock %s: %w", m.Path, err)
+	}
+
+	params, err := pathParamsRegex(m.Path)
+	if err != nil {
+		return fmt.Errorf("invalid path for mock %s: %w", m.Path, err)
+	}
+
+	m.body, m.params = tmpl, params
+	return nil
+}
+
+// pathParamsRegex returns a regular expression capturing the parameters of
+// the path template in named groups.
+func pathParamsRegex(path string) (*regexp.Regexp, error) {
+	greedy := regexp.MustCompile(`\{([^/]+)\+\}`)
+	path = greedy.ReplaceAllString(path, `(?P<$1>.+)`)
+
+	re := regexp.MustCompile(`\{([^/]+)\}`)
+	return regexp.Compile("^" + re.ReplaceAllString(path, `(?P<$1>[^/]+)`) + "$")
+}
+
+// mockHandlers returns the handlers of the mocks.
+func (c *Config) mockHandlers() ([]Handler, error) {
+	handlers := make([]Handler, 0, len(c.Mocks))
+
+	for i := range c.Mocks {
+		mock := &c.Mocks[i]
+		// Mocks of configurations not loaded from a file
+		if mock.body == nil {
+			if err := mock.parse(mock.Body); err != nil {
+				return nil, err
+			}
+		}
+
+		methods := mock.Methods
+		if len(methods) == 0 {
+			methods = []string{anyMethod}
+		}
+
+		handlers = append(handlers, Handler{
+			StagePath:     mock.Path,
+			Path:          mock.Path,
+			Methods:       methods,
+			ListenAddress: mock.ListenAddress,
+			CORS:          c.CORS,
+			Mock:          mock,
+		})
+	}
+
+	return handlers, nil
+}
+
+// serveMock writes the mock response for the request and returns its status.
+func serveMock(w http.ResponseWriter, r *http.Request, mock *MockConfig) int {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		handleError(w, r, err, "Error reading request body")
+		return http.StatusInternalServerError
+	}
+
+	data := mockRequest{
+		Method:  r.Method,
+		Path:    make(map[string]string),
+		Query:   r.URL.Query(),
+		Headers: r.Header,
+		Body:    string(body),
+	}
+	if match := mock.params.FindStringSubmatch(getPath(r.URL)); match != nil {
+		for i, name := range mock.params.SubexpNames() {
+			if name != "" {
+				data.Path[name] = match[i]
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := mock.body.Execute(&buf, data); err != nil {
+		handleError(w, r, err, "Error rendering mock response")
+		return http.StatusInternalServerError
+	}
+
+	for key, value := range mock.Headers {
+		w.Header().Set(key, value)
+	}
+
+	status := mock.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+
+	if _, err := buf.WriteTo(w); err != nil {
+		log.FromContext(r.Context()).Error("Error copying response body", log.Err(err), log.String("path", r.URL.String()), log.String("method", r.Method))
+	}
+
+	return status
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigMocks(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/config/mocks/user.json", []byte(`{"id": "{{ .Path.id }}"}`), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "/config/agbridge.yaml", []byte(`
+mocks:
+  - path: /dev/users/{id}
+    methods: [GET]
+    body_file: mocks/user.json
+`), 0o644))
+
+	config, err := LoadConfig(fs, "/config/agbridge.yaml")
+	require.NoError(t, err)
+	require.Len(t, config.Mocks, 1)
+	require.NotNil(t, config.Mocks[0].body)
+
+	tests := []struct {
+		name   string
+		config string
+		expErr string
+	}{
+		{
+			name:   "Missing body file",
+			config: "mocks:\n  - path: /users\n    body_file: missing.json\n",
+			expErr: "failed to read body file for mock /users",
+		},
+		{
+			name:   "Invalid template",
+			config: "mocks:\n  - path: /users\n    body: '{{ .Path.id '\n",
+			expErr: "invalid body template for mock /users",
+		},
+		{
+			name:   "Invalid status",
+			config: "mocks:\n  - path: /users\n    status: 1000\n",
+			expErr: "invalid status 1000 for mock /users",
+		},
+		{
+			name:   "Body and body file",
+			config: "mocks:\n  - path: /users\n    body: '{}'\n    body_file: users.json\n",
+			expErr: "mock /users can't have both body and body_file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			fs := afero.NewMemMapFs()
+			require.NoError(t, afero.WriteFile(fs, "agbridge.yaml", []byte(tt.config), 0o644))
+
+			_, err := LoadConfig(fs, "agbridge.yaml")
+			require.ErrorContains(t, err, tt.expErr)
+		})
+	}
+}
+
+func TestServeMock(t *testing.T) {
+	t.Parallel()
+
+	mock := &MockConfig{
+		Path:    "/dev/users/{id}/files/{proxy+}",
+		Status:  http.StatusAccepted,
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    `{"id": "{{ .Path.id }}", "file": "{{ .Path.proxy }}", "page": "{{ .Query.Get "page" }}", "method": "{{ .Method }}", "missing": "{{ .Path.missing }}"}`,
+	}
+	require.NoError(t, mock.parse(mock.Body))
+
+	r := httptest.NewRequest(http.MethodPut, "/dev/users/42/files/a/b.txt?page=2", nil)
+	w := httptest.NewRecorder()
+
+	status := serveMock(w, r, mock)
+
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"id": "42", "file": "a/b.txt", "page": "2", "method": "PUT", "missing": ""}`, w.Body.String())
+}
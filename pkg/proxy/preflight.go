@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/oscarbc96/agbridge/pkg/awsutils"
+	"github.com/samber/lo"
+)
+
+// testInvokeActions are the actions the proxy needs to call TestInvokeMethod.
+var testInvokeActions = []string{"apigateway:GET", "apigateway:POST"}
+
+// preflightClient calls the AWS APIs checked by Preflight.
+type preflightClient interface {
+	LoadConfig(profile, region string) (*aws.Config, error)
+	CallerARN(awsCfg aws.Config) (string, error)
+	RestAPIName(awsCfg aws.Config, restAPIID string) (string, error)
+	DescribeStage(awsCfg aws.Config, restAPIID, stageName string) error
+	PrincipalARN(awsCfg aws.Config, callerARN string) (string, error)
+	SimulatePolicy(awsCfg aws.Config, principalARN string, actions []string, resourceARN string) ([]string, error)
+	ProbeTestInvoke(awsCfg aws.Config, restAPIID string) ([]string, error)
+}
+
+// awsPreflightClient is the preflightClient calling AWS.
+type awsPreflightClient struct{}
+
+func (awsPreflightClient) LoadConfig(profile, region string) (*aws.Config, error) {
+	return awsutils.LoadConfigFor(profile, region)
+}
+
+func (awsPreflightClient) CallerARN(awsCfg aws.Config) (string, error) {
+	_, callerARN, err := awsutils.GetAccountDetails(awsCfg)
+	return callerARN, err
+}
+
+func (awsPreflightClient) RestAPIName(awsCfg aws.Config, restAPIID string) (string, error) {
+	restAPI, err := awsutils.DescribeRestAPI(awsCfg, restAPIID)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(restAPI.Name), nil
+}
+
+func (awsPreflightClient) DescribeStage(awsCfg aws.Config, restAPIID, stageName string) error {
+	_, err := awsutils.DescribeStage(awsCfg, restAPIID, stageName)
+	return err
+}
+
+func (awsPreflightClient) PrincipalARN(awsCfg aws.Config, callerARN string) (string, error) {
+	return awsutils.PrincipalARN(awsCfg, callerARN)
+}
+
+func (awsPreflightClient) SimulatePolicy(awsCfg aws.Config, principalARN string, actions []string, resourceARN string) ([]string, error) {
+	return awsutils.SimulatePolicy(awsCfg, principalARN, actions, resourceARN)
+}
+
+func (awsPreflightClient) ProbeTestInvoke(awsCfg aws.Config, restAPIID string) ([]string, error) {
+	return awsutils.ProbeTestInvoke(awsCfg, restAPIID)
+}
+
+// PreflightCheck is the result of a check run by Preflight for a gateway.
+type PreflightCheck struct {
+	RestAPIID string `json:"rest_api_id"`
+	StageName string `json:"stage_name,omitempty"`
+	Name      string `json:"name"`
+	Passed    bool   `json:"passed"`
+	Detail    string `json:"detail,omitempty"`
+	// Hint suggests how to fix a failed check.
+	Hint string `json:"hint,omitempty"`
+}
+
+// Preflight checks, for every gateway, that the credentials resolve, the Rest
+// API and stage exist and the caller is allowed to call TestInvokeMethod. The
+// permissions are checked with IAM policy simulation, or by probing API
+// Gateway when the caller can't simulate its policies, like when iam:GetRole
+// or iam:SimulatePrincipalPolicy are denied. When every check
+// passes the routes are loaded, with a check per gateway.
+func (p *Proxy) Preflight() []PreflightCheck {
+	var (
+		wg     sync.WaitGroup
+		checks = make([][]PreflightCheck, len(p.config.Gateways))
+	)
+
+	for i, gw := range p.config.Gateways {
+		wg.Add(1)
+		go func(i int, gw GatewayConfig) {
+			defer wg.Done()
+			checks[i] = gw.preflight(p.preflight)
+		}(i, gw)
+	}
+
+	wg.Wait()
+
+	result := lo.Flatten(checks)
+	if lo.SomeBy(result, func(check PreflightCheck) bool { return !check.Passed }) {
+		return result
+	}
+
+	// The errors of the gateways are reported by their status, any other
+	// error comes from the route overrides and mocks
+	err := p.Reload()
+	gateways := p.Gateways()
+	if lo.SomeBy(gateways, func(gw GatewayStatus) bool { return gw.Error != "" }) {
+		err = nil
+	}
+	for _, gw := range gateways {
+		check := PreflightCheck{RestAPIID: gw.RestAPIID, StageName: gw.StageName, Name: "routes", Passed: gw.Error == "" && err == nil}
+		switch {
+		case gw.Error != "":
+			check.Detail = gw.Error
+		case err != nil:
+			check.Detail = err.Error()
+			check.Hint = "Check the route overrides and mocks of the configuration."
+		default:
+			check.Detail = fmt.Sprintf("%d routes", gw.Routes)
+		}
+		result = append(result, check)
+	}
+
+	return result
+}
+
+// preflight runs the checks of the gateway, stopping at the first one that
+// fails.
+func (gw GatewayConfig) preflight(client preflightClient) []PreflightCheck {
+	var checks []PreflightCheck
+	check := func(name string, err error, detail, hint string) bool {
+		result := PreflightCheck{RestAPIID: gw.RestAPIID, StageName: gw.StageName, Name: name, Passed: err == nil, Detail: detail}
+		if err != nil {
+			result.Detail, result.Hint = err.Error(), hint
+		}
+		checks = append(checks, result)
+		return err == nil
+	}
+
+	credentialsHint := "Check the profile and region, log in with `aws sso login` or export AWS credentials."
+	if gw.ProfileName != "" {
+		credentialsHint = fmt.Sprintf("Check the profile %s and region, log in with `aws sso login --profile %s` or refresh its credentials.", gw.ProfileName, gw.ProfileName)
+	}
+
+	awsCfg, err := client.LoadConfig(gw.ProfileName, gw.Region)
+	var callerARN string
+	if err == nil {
+		callerARN, err = client.CallerARN(*awsCfg)
+	}
+	if !check("credentials", err, callerARN, credentialsHint) {
+		return checks
+	}
+
+	restAPIName, err := client.RestAPIName(*awsCfg, gw.RestAPIID)
+	if !check("rest api", err, restAPIName, fmt.Sprintf("Check the Rest API ID and the region %s, list the Rest APIs with `agbridge discover`.", awsCfg.Region)) {
+		return checks
+	}
+
+	if gw.StageName != "" {
+		err := client.DescribeStage(*awsCfg, gw.RestAPIID, gw.StageName)
+		if !check("stage", err, gw.StageName, "Check the stage name, list the stages with `agbridge discover`.") {
+			return checks
+		}
+	}
+
+	denied, method, err := checkTestInvoke(client, *awsCfg, gw.RestAPIID, callerARN)
+	if err == nil && len(denied) > 0 {
+		err = fmt.Errorf("%s not allowed on %s, %s", strings.Join(denied, ", "), awsutils.TestInvokeARN(awsCfg.Region, gw.RestAPIID), method)
+	}
+	check("permissions", err, strings.Join(testInvokeActions, ", ")+" allowed, "+method,
+		fmt.Sprintf("Allow %s on %s to %s.", strings.Join(testInvokeActions, " and "), awsutils.TestInvokeARN(awsCfg.Region, gw.RestAPIID), callerARN))
+
+	return checks
+}
+
+// checkTestInvoke returns the TestInvokeMethod actions the caller is denied
+// and how they were checked. The policies of the caller are simulated when its
+// principal can be looked up, API Gateway is probed otherwise.
+func checkTestInvoke(client preflightClient, awsCfg aws.Config, restAPIID, callerARN string) ([]string, string, error) {
+	principalARN, err := client.PrincipalARN(awsCfg, callerARN)
+	if err == nil {
+		denied, err := client.SimulatePolicy(awsCfg, principalARN, testInvokeActions, awsutils.TestInvokeARN(awsCfg.Region, restAPIID))
+		if err == nil {
+			return denied, "simulated with IAM", nil
+		}
+	}
+
+	denied, err := client.ProbeTestInvoke(awsCfg, restAPIID)
+	return denied, "probed API Gateway", err
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePreflightClient answers the preflight checks with its fields.
+type fakePreflightClient struct {
+	configErr    error
+	callerARN    string
+	callerErr    error
+	restAPIErr   error
+	stageErr     error
+	principalErr error
+	simulated    []string
+	simulateErr  error
+	probed       []string
+	probeErr     error
+
+	simulatedARN string
+}
+
+func (c *fakePreflightClient) LoadConfig(_, region string) (*aws.Config, error) {
+	if c.configErr != nil {
+		return nil, c.configErr
+	}
+	return &aws.Config{Region: region}, nil
+}
+
+func (c *fakePreflightClient) CallerARN(aws.Config) (string, error) {
+	return c.callerARN, c.callerErr
+}
+
+func (c *fakePreflightClient) RestAPIName(_ aws.Config, restAPIID string) (string, error) {
+	return "api-" + restAPIID, c.restAPIErr
+}
+
+func (c *fakePreflightClient) DescribeStage(aws.Config, string, string) error {
+	return c.stageErr
+}
+
+func (c *fakePreflightClient) PrincipalARN(_ aws.Config, callerARN string) (string, error) {
+	if c.principalErr != nil {
+		return "", c.principalErr
+	}
+	return "arn:aws:iam::123456789012:role/aws-reserved/sso.amazonaws.com/eu-west-1/AWSReservedSSO_Admin_0123", nil
+}
+
+func (c *fakePreflightClient) SimulatePolicy(_ aws.Config, principalARN string, _ []string, _ string) ([]string, error) {
+	c.simulatedARN = principalARN
+	return c.simulated, c.simulateErr
+}
+
+func (c *fakePreflightClient) ProbeTestInvoke(aws.Config, string) ([]string, error) {
+	return c.probed, c.probeErr
+}
+
+const preflightCallerARN = "arn:aws:sts::123456789012:assumed-role/AWSReservedSSO_Admin_0123/dev"
+
+func TestCheckTestInvoke(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		client    *fakePreflightClient
+		expDenied []string
+		expMethod string
+		expErr    string
+		expARN    string
+	}{
+		{
+			name:      "Simulated",
+			client:    &fakePreflightClient{simulated: []string{"apigateway:POST"}},
+			expDenied: []string{"apigateway:POST"},
+			expMethod: "simulated with IAM",
+			expARN:    "arn:aws:iam::123456789012:role/aws-reserved/sso.amazonaws.com/eu-west-1/AWSReservedSSO_Admin_0123",
+		},
+		{
+			name:      "Role not found",
+			client:    &fakePreflightClient{principalErr: errors.New("access denied"), probed: []string{"apigateway:GET"}},
+			expDenied: []string{"apigateway:GET"},
+			expMethod: "probed API Gateway",
+		},
+		{
+			name:      "Simulation denied",
+			client:    &fakePreflightClient{simulateErr: errors.New("access denied")},
+			expMethod: "probed API Gateway",
+			expARN:    "arn:aws:iam::123456789012:role/aws-reserved/sso.amazonaws.com/eu-west-1/AWSReservedSSO_Admin_0123",
+		},
+		{
+			name:      "Probe failed",
+			client:    &fakePreflightClient{simulateErr: errors.New("access denied"), probeErr: errors.New("throttled")},
+			expMethod: "probed API Gateway",
+			expErr:    "throttled",
+			expARN:    "arn:aws:iam::123456789012:role/aws-reserved/sso.amazonaws.com/eu-west-1/AWSReservedSSO_Admin_0123",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			denied, method, err := checkTestInvoke(tt.client, aws.Config{Region: "eu-west-1"}, "abc123", preflightCallerARN)
+			if tt.expErr != "" {
+				require.EqualError(t, err, tt.expErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expDenied, denied)
+			assert.Equal(t, tt.expMethod, method)
+			assert.Equal(t, tt.expARN, tt.client.simulatedARN)
+		})
+	}
+}
+
+func TestGatewayPreflight(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		gw     GatewayConfig
+		client *fakePreflightClient
+		exp    []PreflightCheck
+	}{
+		{
+			name:   "Credentials failed",
+			gw:     GatewayConfig{RestAPIID: "abc123", ProfileName: "dev", Region: "eu-west-1"},
+			client: &fakePreflightClient{callerErr: errors.New("token expired")},
+			exp: []PreflightCheck{
+				{RestAPIID: "abc123", Name: "credentials", Detail: "token expired", Hint: "Check the profile dev and region, log in with `aws sso login --profile dev` or refresh its credentials."},
+			},
+		},
+		{
+			name:   "Rest API not found",
+			gw:     GatewayConfig{RestAPIID: "abc123", Region: "eu-west-1"},
+			client: &fakePreflightClient{callerARN: preflightCallerARN, restAPIErr: errors.New("not found")},
+			exp: []PreflightCheck{
+				{RestAPIID: "abc123", Name: "credentials", Passed: true, Detail: preflightCallerARN},
+				{RestAPIID: "abc123", Name: "rest api", Detail: "not found", Hint: "Check the Rest API ID and the region eu-west-1, list the Rest APIs with `agbridge discover`."},
+			},
+		},
+		{
+			name:   "Stage not found",
+			gw:     GatewayConfig{RestAPIID: "abc123", Region: "eu-west-1", StageName: "prod"},
+			client: &fakePreflightClient{callerARN: preflightCallerARN, stageErr: errors.New("not found")},
+			exp: []PreflightCheck{
+				{RestAPIID: "abc123", StageName: "prod", Name: "credentials", Passed: true, Detail: preflightCallerARN},
+				{RestAPIID: "abc123", StageName: "prod", Name: "rest api", Passed: true, Detail: "api-abc123"},
+				{RestAPIID: "abc123", StageName: "prod", Name: "stage", Detail: "not found", Hint: "Check the stage name, list the stages with `agbridge discover`."},
+			},
+		},
+		{
+			name:   "Permissions denied",
+			gw:     GatewayConfig{RestAPIID: "abc123", Region: "eu-west-1"},
+			client: &fakePreflightClient{callerARN: preflightCallerARN, simulated: []string{"apigateway:POST"}},
+			exp: []PreflightCheck{
+				{RestAPIID: "abc123", Name: "credentials", Passed: true, Detail: preflightCallerARN},
+				{RestAPIID: "abc123", Name: "rest api", Passed: true, Detail: "api-abc123"},
+				{
+					RestAPIID: "abc123", Name: "permissions",
+					Detail: "apigateway:POST not allowed on arn:aws:apigateway:eu-west-1::/restapis/abc123/resources/*/methods/*, simulated with IAM",
+					Hint:   "Allow apigateway:GET and apigateway:POST on arn:aws:apigateway:eu-west-1::/restapis/abc123/resources/*/methods/* to " + preflightCallerARN + ".",
+				},
+			},
+		},
+		{
+			name:   "Every check passes",
+			gw:     GatewayConfig{RestAPIID: "abc123", Region: "eu-west-1", StageName: "prod"},
+			client: &fakePreflightClient{callerARN: preflightCallerARN, principalErr: errors.New("access denied")},
+			exp: []PreflightCheck{
+				{RestAPIID: "abc123", StageName: "prod", Name: "credentials", Passed: true, Detail: preflightCallerARN},
+				{RestAPIID: "abc123", StageName: "prod", Name: "rest api", Passed: true, Detail: "api-abc123"},
+				{RestAPIID: "abc123", StageName: "prod", Name: "stage", Passed: true, Detail: "prod"},
+				{
+					RestAPIID: "abc123", StageName: "prod", Name: "permissions", Passed: true,
+					Detail: "apigateway:GET, apigateway:POST allowed, probed API Gateway",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.exp, tt.gw.preflight(tt.client))
+		})
+	}
+}
+
+func TestProxyPreflight(t *testing.T) {
+	t.Parallel()
+
+	newProxy := func(client *fakePreflightClient) *Proxy {
+		p := NewProxy(nil, &Config{Gateways: []GatewayConfig{{RestAPIID: "abc123", Region: "eu-west-1"}, {RestAPIID: "def456", Region: "eu-west-1"}}})
+		p.preflight = client
+		p.resolve = func(gw GatewayConfig) ([]Handler, error) {
+			if gw.RestAPIID == "def456" {
+				return nil, errors.New("throttled")
+			}
+			return []Handler{{StagePath: "/users", Path: "/users", RestAPIID: gw.RestAPIID, Methods: []string{http.MethodGet}}}, nil
+		}
+		return p
+	}
+
+	// Failing checks don't load the routes
+	p := newProxy(&fakePreflightClient{callerErr: errors.New("token expired")})
+	checks := p.Preflight()
+	require.Len(t, checks, 2)
+	assert.Equal(t, []string{"credentials", "credentials"}, []string{checks[0].Name, checks[1].Name})
+	assert.Empty(t, p.HandlerMapping())
+
+	// Passing checks load the routes, with a check per gateway
+	p = newProxy(&fakePreflightClient{callerARN: preflightCallerARN})
+	checks = p.Preflight()
+	require.Len(t, checks, 8)
+	assert.Equal(t, PreflightCheck{RestAPIID: "abc123", Name: "routes", Passed: true, Detail: "1 routes"}, checks[6])
+	assert.Equal(t, PreflightCheck{RestAPIID: "def456", Name: "routes", Detail: "throttled"}, checks[7])
+	assert.Len(t, p.HandlerMapping(), 1)
+
+	// Invalid route overrides fail every gateway
+	p = newProxy(&fakePreflightClient{callerARN: preflightCallerARN})
+	p.config.Mocks = []MockConfig{{Path: "/health", Body: "{{ .Path"}}
+	checks = p.Preflight()
+	require.Len(t, checks, 8)
+	assert.False(t, checks[6].Passed)
+	assert.Equal(t, "Check the route overrides and mocks of the configuration.", checks[7].Hint)
+}
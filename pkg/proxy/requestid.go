@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"github.com/oscarbc96/agbridge/pkg/log"
+)
+
+const (
+	RequestIDHeader = "X-Request-Id"
+	AmznTraceHeader = "X-Amzn-Trace-Id"
+)
+
+// maxRequestIDLength bounds the IDs reused from the caller.
+const maxRequestIDLength = 128
+
+// validRequestID matches the IDs safe to reuse in logs, headers and error
+// bodies, like UUIDs and Amazon trace IDs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:;=-]+$`)
+
+// requestID reuses the ID sent by the caller when valid, or generates a new
+// one.
+func requestID(r *http.Request) string {
+	for _, header := range []string{RequestIDHeader, AmznTraceHeader} {
+		if id := r.Header.Get(header); len(id) <= maxRequestIDLength && validRequestID.MatchString(id) {
+			return id
+		}
+	}
+
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withRequestID identifies every request. The ID is forwarded upstream,
+// returned in the response and attached to every log line of the request.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+
+		r.Header.Set(RequestIDHeader, id)
+		w.Header().Set(RequestIDHeader, id)
+
+		logger := log.FromContext(r.Context()).With(log.String("request_id", id))
+		next.ServeHTTP(w, r.WithContext(log.NewContext(r.Context(), logger)))
+	})
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		exp     string
+	}{
+		{name: "Request ID", headers: map[string]string{RequestIDHeader: "abc", AmznTraceHeader: "Root=1-abc"}, exp: "abc"},
+		{name: "Amazon trace ID", headers: map[string]string{AmznTraceHeader: "Root=1-abc"}, exp: "Root=1-abc"},
+		{name: "Generated", headers: map[string]string{}},
+		{name: "Invalid request ID", headers: map[string]string{RequestIDHeader: "abc\" injected", AmznTraceHeader: "Root=1-abc;Sampled=1"}, exp: "Root=1-abc;Sampled=1"},
+		{name: "Too long", headers: map[string]string{RequestIDHeader: strings.Repeat("a", 129)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+
+			id := requestID(r)
+			if tt.exp == "" {
+				assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{32}$`), id)
+				return
+			}
+			assert.Equal(t, tt.exp, id)
+		})
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	t.Parallel()
+
+	var forwarded string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(RequestIDHeader)
+	}))
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(AmznTraceHeader, "Root=1-abc")
+	handler.ServeHTTP(rec, r)
+
+	assert.Equal(t, "Root=1-abc", forwarded)
+	assert.Equal(t, []string{"Root=1-abc"}, rec.Header().Values(RequestIDHeader))
+}
+
+func TestRequestIDReplacesResponseID(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(RequestIDHeader, "upstream-id")
+	}))
+	t.Cleanup(upstream.Close)
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	awsCfg := newFakeAPIGateway(t, respondTestInvoke(testInvokeResponse{
+		Status:            http.StatusOK,
+		MultiValueHeaders: map[string][]string{RequestIDHeader: {"api-id"}},
+	}))
+
+	tests := []struct {
+		name    string
+		mapping map[*regexp.Regexp]Handler
+		method  string
+	}{
+		{
+			name:    "Upstream",
+			mapping: map[*regexp.Regexp]Handler{regexp.MustCompile(`^/dev/users$`): {StagePath: "/dev/users", Methods: []string{anyMethod}, Upstream: upstreamURL}},
+			method:  http.MethodGet,
+		},
+		{
+			name:    "API Gateway",
+			mapping: testInvokeMapping(awsCfg, "request-id"),
+			method:  http.MethodPost,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defaultHandleRequest(w, r, tt.mapping, newIdentityResolver(nil), nil, nil)
+			}))
+
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, "/dev/users", nil)
+			r.Header.Set(RequestIDHeader, "proxy-id")
+			handler.ServeHTTP(rec, r)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, []string{"proxy-id"}, rec.Header().Values(RequestIDHeader))
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/oscarbc96/agbridge/pkg/awsutils"
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"github.com/spf13/afero"
+)
+
+// RouteCache persists the routes resolved for every gateway in a directory,
+// with a file per gateway named after its Rest API ID, profile, region and
+// stage.
+type RouteCache struct {
+	fs  afero.Fs
+	dir string
+}
+
+type routeCacheEntry struct {
+	RestAPIID   string        `json:"rest_api_id"`
+	ProfileName string        `json:"profile_name,omitempty"`
+	StoredAt    time.Time     `json:"stored_at"`
+	Routes      gatewayRoutes `json:"routes"`
+}
+
+func NewRouteCache(fs afero.Fs, dir string) *RouteCache {
+	return &RouteCache{fs: fs, dir: dir}
+}
+
+func (c *RouteCache) filename(gw GatewayConfig) string {
+	sum := sha256.Sum256([]byte(gw.RestAPIID + "\x00" + gw.ProfileName + "\x00" + gw.Region + "\x00" + gw.StageName))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// resolve resolves the routes of the gateway from API Gateway and stores them
+// in the cache. Failing to store them is logged but not returned.
+func (c *RouteCache) resolve(gw GatewayConfig) ([]Handler, error) {
+	awsCfg, routes, err := gw.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.store(gw, routes); err != nil {
+		log.Warn("Failed to cache routes", log.Err(err), log.String("rest_api_id", gw.RestAPIID))
+	}
+
+	return gw.handlers(*awsCfg, routes), nil
+}
+
+// load returns the handlers of the gateway read from the cache, without
+// calling AWS. The AWS configuration is loaded when possible so requests can
+// still be sent to API Gateway.
+func (c *RouteCache) load(gw GatewayConfig) ([]Handler, error) {
+	data, err := afero.ReadFile(c.fs, c.filename(gw))
+	if err != nil {
+		return nil, fmt.Errorf("no cached routes for Rest API ID %s: %w", gw.RestAPIID, err)
+	}
+
+	var entry routeCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("invalid cached routes for Rest API ID %s: %w", gw.RestAPIID, err)
+	}
+
+	awsCfg := aws.Config{Region: entry.Routes.Region}
+	if cfg, err := awsutils.LoadConfigFor(gw.ProfileName, gw.Region); err == nil {
+		awsCfg = *cfg
+	} else {
+		log.Warn("Failed to load AWS config of cached routes, API Gateway requests will fail", log.Err(err), log.String("rest_api_id", gw.RestAPIID))
+	}
+
+	return gw.handlers(awsCfg, &entry.Routes), nil
+}
+
+func (c *RouteCache) store(gw GatewayConfig, routes *gatewayRoutes) error {
+	data, err := json.MarshalIndent(routeCacheEntry{
+		RestAPIID:   gw.RestAPIID,
+		ProfileName: gw.ProfileName,
+		StoredAt:    time.Now().UTC(),
+		Routes:      *routes,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := c.fs.MkdirAll(c.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create route cache directory: %w", err)
+	}
+
+	// Write to a temporary file renamed over the entry, so concurrent runs
+	// never read it partially written
+	filename := c.filename(gw)
+	tmp := fmt.Sprintf("%s.%d.tmp", filename, os.Getpid())
+	if err := afero.WriteFile(c.fs, tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := c.fs.Rename(tmp, filename); err != nil {
+		_ = c.fs.Remove(tmp)
+		return err
+	}
+	return nil
+}
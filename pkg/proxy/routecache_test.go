@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteCache(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/nonexistent")
+
+	cache := NewRouteCache(afero.NewMemMapFs(), "/cache")
+	gw := GatewayConfig{RestAPIID: "abc123", Region: "eu-west-1", StageName: "dev"}
+
+	_, err := cache.load(gw)
+	require.ErrorContains(t, err, "no cached routes for Rest API ID abc123")
+
+	require.NoError(t, cache.store(gw, &gatewayRoutes{
+		Region:         "eu-west-1",
+		StageName:      "dev",
+		StageVariables: map[string]string{"env": "dev"},
+		Resources:      []gatewayResource{{Path: "/users/{id}", ResourceID: "res", Methods: []string{"GET"}}},
+		AccountID:      "123456789012",
+		Identity:       "arn:aws:iam::123456789012:user/dev",
+	}))
+
+	_, err = cache.load(GatewayConfig{RestAPIID: "abc123", Region: "eu-west-1", StageName: "prod"})
+	require.Error(t, err, "expected the stage to be part of the cache key")
+
+	proxy := NewProxy(nil, &Config{Gateways: []GatewayConfig{gw}})
+	proxy.UseRouteCache(cache, true)
+	require.NoError(t, proxy.Reload())
+
+	routes, err := DescribeRoutes(proxy.HandlerMapping())
+	require.NoError(t, err, "expected the cached identity to be used instead of STS")
+	assert.Equal(t, []Route{{
+		Path:           "/dev/users/{id}",
+		Methods:        []string{"GET"},
+		StageVariables: map[string]string{"env": "dev"},
+		RestAPIID:      "abc123",
+		ResourceID:     "res",
+		AccountID:      "123456789012",
+		Region:         "eu-west-1",
+		Identity:       "arn:aws:iam::123456789012:user/dev",
+	}}, routes)
+}
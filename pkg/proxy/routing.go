@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// anyMethod is the method API Gateway uses for resources accepting every
+// method.
+const anyMethod = "ANY"
+
+func (h *Handler) supportsMethod(method string) bool {
+	return lo.Contains(h.Methods, method) || lo.Contains(h.Methods, anyMethod)
+}
+
+// rank orders the handlers matching the same request, higher first. Like API
+// Gateway, it resolves the resource before the method: mocks and route
+// overrides supporting the method come first, then every other route by
+// specificity, paths with fewer parameters, like `/users/me` over
+// `/users/{id}`, and longer literals, and the fallback upstream last. Method
+// support only breaks ties, so a request to `/users/me` with a method only
+// `/users/{id}` has is reported as not supported.
+func (h *Handler) rank(method string) []int {
+	supported := h.supportsMethod(method)
+
+	kind := 1
+	switch {
+	case h.Fallback:
+		kind = 0
+	case h.Mock != nil && supported:
+		kind = 3
+	case h.Upstream != nil && supported:
+		kind = 2
+	}
+
+	return []int{
+		kind,
+		-strings.Count(h.StagePath, "{"),
+		len(h.StagePath),
+		lo.Ternary(supported, 1, 0),
+	}
+}
+
+// findHandler returns the best handler for the path and method, or nil when
+// no handler matches the path.
+func findHandler(handlerMapping map[*regexp.Regexp]Handler, path, method string) *Handler {
+	var (
+		best     *Handler
+		bestRank []int
+	)
+
+	for pattern, h := range handlerMapping {
+		if !pattern.MatchString(path) {
+			continue
+		}
+
+		rank := h.rank(method)
+		if best == nil {
+			best, bestRank = &h, rank
+			continue
+		}
+
+		// Break ties on the path to not depend on the map iteration order
+		if cmp := compareRanks(rank, bestRank); cmp > 0 || (cmp == 0 && h.StagePath < best.StagePath) {
+			best, bestRank = &h, rank
+		}
+	}
+
+	return best
+}
+
+func compareRanks(a, b []int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return 0
+}
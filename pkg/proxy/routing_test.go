@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRouteTable(t *testing.T, handlers ...Handler) map[*regexp.Regexp]Handler {
+	t.Helper()
+
+	routes, err := buildRouteTables([][]Handler{handlers})
+	require.NoError(t, err)
+	return routes[""]
+}
+
+func TestFindHandler(t *testing.T) {
+	t.Parallel()
+
+	upstream, err := url.Parse("http://localhost:3000")
+	require.NoError(t, err)
+
+	handlerMapping := newRouteTable(t,
+		Handler{StagePath: "/dev/users/{id}", RestAPIID: "api", Methods: []string{"GET", "DELETE"}},
+		Handler{StagePath: "/dev/users/me", RestAPIID: "api", Methods: []string{"GET"}},
+		Handler{StagePath: "/dev/files/{proxy+}", RestAPIID: "api", Methods: []string{"ANY"}},
+		Handler{StagePath: "/dev/users/{id}", Methods: []string{"POST"}, Upstream: upstream},
+		Handler{StagePath: "/dev/orders/{proxy+}", Methods: []string{"ANY"}, Upstream: upstream},
+		Handler{StagePath: fallbackPath, Methods: []string{"ANY"}, Upstream: upstream, Fallback: true},
+		Handler{StagePath: "/dev/users/{id}", Methods: []string{"DELETE"}, Mock: &MockConfig{}},
+	)
+
+	tests := []struct {
+		name     string
+		path     string
+		method   string
+		expPath  string
+		expRoute bool
+		expMock  bool
+	}{
+		{name: "Gateway route", path: "/dev/users/42", method: "GET", expPath: "/dev/users/{id}"},
+		{name: "Literal before parameter", path: "/dev/users/me", method: "GET", expPath: "/dev/users/me"},
+		{name: "Override for method", path: "/dev/users/42", method: "POST", expPath: "/dev/users/{id}", expRoute: true},
+		{name: "Mock before gateway route", path: "/dev/users/42", method: "DELETE", expPath: "/dev/users/{id}", expMock: true},
+		{name: "Greedy path", path: "/dev/files/a/b/c.txt", method: "PUT", expPath: "/dev/files/{proxy+}"},
+		{name: "Override with greedy path", path: "/dev/orders/1/items", method: "GET", expPath: "/dev/orders/{proxy+}", expRoute: true},
+		{name: "Unsupported method keeps the resource", path: "/dev/users/me", method: "PATCH", expPath: "/dev/users/me"},
+		{name: "Unmatched path falls back", path: "/health", method: "GET", expPath: fallbackPath, expRoute: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := findHandler(handlerMapping, tt.path, tt.method)
+			require.NotNil(t, handler)
+			assert.Equal(t, tt.expPath, handler.StagePath)
+			assert.Equal(t, tt.expRoute, handler.Upstream != nil)
+			assert.Equal(t, tt.expMock, handler.Mock != nil)
+		})
+	}
+}
+
+func TestFindHandlerWithoutMatch(t *testing.T) {
+	t.Parallel()
+
+	handlerMapping := newRouteTable(t,
+		Handler{StagePath: "/users/{id}", RestAPIID: "api", Methods: []string{"GET"}},
+	)
+
+	assert.Nil(t, findHandler(handlerMapping, "/orders", "GET"))
+
+	// Matching paths are returned even without the method, to report it
+	handler := findHandler(handlerMapping, "/users/42", "POST")
+	require.NotNil(t, handler)
+	assert.False(t, handler.supportsMethod("POST"))
+}
+
+func TestFindHandlerResolvesPathBeforeMethod(t *testing.T) {
+	t.Parallel()
+
+	upstream, err := url.Parse("http://localhost:8000")
+	require.NoError(t, err)
+
+	handlerMapping := newRouteTable(t,
+		Handler{StagePath: "/users/{id}", RestAPIID: "api", Methods: []string{"GET"}},
+		Handler{StagePath: "/users/me", RestAPIID: "api", Methods: []string{"DELETE"}},
+		Handler{StagePath: fallbackPath, Methods: []string{"ANY"}, Upstream: upstream, Fallback: true},
+	)
+
+	// The most specific resource wins even without the method
+	handler := findHandler(handlerMapping, "/users/me", "GET")
+	require.NotNil(t, handler)
+	assert.Equal(t, "/users/me", handler.StagePath)
+	assert.False(t, handler.supportsMethod("GET"))
+
+	// The fallback upstream never wins over a resource
+	handler = findHandler(handlerMapping, "/users/42", "POST")
+	require.NotNil(t, handler)
+	assert.Equal(t, "/users/{id}", handler.StagePath)
+	assert.False(t, handler.Fallback)
+}
+
+func TestServeUpstream(t *testing.T) {
+	t.Parallel()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "http://evil.example.com")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.RequestURI())
+	}))
+	defer backend.Close()
+
+	upstream, err := url.Parse(backend.URL + "/v2")
+	require.NoError(t, err)
+
+	handler := &Handler{
+		StagePath:   "/dev/orders/{proxy+}",
+		Methods:     []string{"ANY"},
+		Upstream:    upstream,
+		StripPrefix: "/dev",
+		CORS:        &CORSConfig{AllowedOrigins: []string{"*"}},
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/dev/orders/1?expand=items", nil)
+	r.Header.Set("Origin", "http://localhost:5173")
+	w := httptest.NewRecorder()
+
+	status := serveUpstream(w, r, handler)
+
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "POST /v2/orders/1?expand=items", w.Body.String())
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestGatewayPathPrefix(t *testing.T) {
+	t.Parallel()
+
+	routes := &gatewayRoutes{
+		StageName: "prod",
+		Resources: []gatewayResource{{Path: "/items/{id}", ResourceID: "res", Methods: []string{"GET"}}},
+	}
+
+	handlers := GatewayConfig{RestAPIID: "abc", PathPrefix: "/orders"}.handlers(aws.Config{}, routes)
+	require.Len(t, handlers, 1)
+	assert.Equal(t, "/orders/prod/items/{id}", handlers[0].StagePath)
+	assert.Equal(t, "/items/{id}", handlers[0].Path)
+
+	handlers = GatewayConfig{RestAPIID: "abc", PathPrefix: "/orders", ListenAddress: ":9090"}.handlers(aws.Config{}, routes)
+	assert.Equal(t, "/orders/items/{id}", handlers[0].StagePath)
+
+	for _, prefix := range []string{"orders", "/orders/"} {
+		config := "gateways:\n  - rest_api_id: abc\n    path_prefix: " + prefix + "\n"
+		_, err := ReadConfig(afero.NewMemMapFs(), strings.NewReader(config), ".")
+		require.ErrorContains(t, err, "invalid path_prefix")
+	}
+}
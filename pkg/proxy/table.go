@@ -1,46 +1,314 @@
 package proxy
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"regexp"
+	"sort"
+	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/oscarbc96/agbridge/pkg/awsutils"
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
 )
 
+type RouteFormat string
+
+const (
+	RouteFormatTable    RouteFormat = "table"
+	RouteFormatJSON     RouteFormat = "json"
+	RouteFormatYAML     RouteFormat = "yaml"
+	RouteFormatCSV      RouteFormat = "csv"
+	RouteFormatMarkdown RouteFormat = "markdown"
+)
+
+func ParseRouteFormat(format string) (RouteFormat, error) {
+	switch RouteFormat(strings.ToLower(format)) {
+	case RouteFormatTable:
+		return RouteFormatTable, nil
+	case RouteFormatJSON:
+		return RouteFormatJSON, nil
+	case RouteFormatYAML:
+		return RouteFormatYAML, nil
+	case RouteFormatCSV:
+		return RouteFormatCSV, nil
+	case RouteFormatMarkdown:
+		return RouteFormatMarkdown, nil
+	default:
+		return RouteFormatTable, errors.New("invalid output format: must be one of table, json, yaml, csv, markdown")
+	}
+}
+
+type Route struct {
+	Path           string            `json:"path" yaml:"path"`
+	Methods        []string          `json:"methods" yaml:"methods"`
+	StageVariables map[string]string `json:"stage_variables,omitempty" yaml:"stage_variables,omitempty"`
+	RestAPIID      string            `json:"rest_api_id" yaml:"rest_api_id"`
+	ResourceID     string            `json:"resource_id" yaml:"resource_id"`
+	AccountID      string            `json:"account_id" yaml:"account_id"`
+	Region         string            `json:"region" yaml:"region"`
+	Identity       string            `json:"identity" yaml:"identity"`
+	ListenAddress  string            `json:"listen_address,omitempty" yaml:"listen_address,omitempty"`
+	Upstream       string            `json:"upstream,omitempty" yaml:"upstream,omitempty"`
+	Mock           bool              `json:"mock,omitempty" yaml:"mock,omitempty"`
+	// MethodDetails describe the methods of API Gateway routes, when
+	// requested with DescribeRouteMethods.
+	MethodDetails []MethodDetails `json:"method_details,omitempty" yaml:"method_details,omitempty"`
+}
+
+// MethodDetails describe how API Gateway authorizes and integrates a method.
+type MethodDetails struct {
+	Method            string `json:"method" yaml:"method"`
+	AuthorizationType string `json:"authorization_type" yaml:"authorization_type"`
+	APIKeyRequired    bool   `json:"api_key_required" yaml:"api_key_required"`
+	IntegrationType   string `json:"integration_type,omitempty" yaml:"integration_type,omitempty"`
+	IntegrationURI    string `json:"integration_uri,omitempty" yaml:"integration_uri,omitempty"`
+}
+
+// DescribeRoutes returns the routes served by the handler mapping sorted by
+// path. The caller identity is looked up once per Rest API, mocks and routes
+// sent to an upstream don't use AWS.
+func DescribeRoutes(handlerMapping map[*regexp.Regexp]Handler) ([]Route, error) {
+	return describeRoutes(handlerMapping, false, true)
+}
+
+// DescribeRouteMethods is like DescribeRoutes, adding the details of every
+// method of the API Gateway routes. It makes a GetMethod call per method.
+func DescribeRouteMethods(handlerMapping map[*regexp.Regexp]Handler) ([]Route, error) {
+	return describeRoutes(handlerMapping, true, true)
+}
+
+// loadedRoutes is like DescribeRoutes without calling AWS, the routes have the
+// identity recorded when they were loaded.
+func loadedRoutes(handlerMapping map[*regexp.Regexp]Handler) []Route {
+	routes, _ := describeRoutes(handlerMapping, false, false)
+	return routes
+}
+
+func describeRoutes(handlerMapping map[*regexp.Regexp]Handler, withMethods, lookupIdentity bool) ([]Route, error) {
+	type account struct{ id, identity string }
+	accounts := make(map[string]account)
+
+	routes := make([]Route, 0, len(handlerMapping))
+	for _, handler := range handlerMapping {
+		if handler.local() {
+			route := Route{
+				Path:          handler.StagePath,
+				Methods:       handler.Methods,
+				ListenAddress: handler.ListenAddress,
+				Mock:          handler.Mock != nil,
+			}
+			if handler.Upstream != nil {
+				route.Upstream = handler.Upstream.Redacted()
+			}
+			routes = append(routes, route)
+			continue
+		}
+
+		// Gateways sharing a Rest API ID can use other profiles and regions
+		key := strings.Join([]string{handler.RestAPIID, handler.ProfileName, handler.Config.Region}, "\x00")
+		acc, ok := accounts[key]
+		if !ok {
+			// Handlers record their identity when they load
+			acc = account{id: handler.AccountID, identity: handler.Identity}
+			if acc.identity == "" && lookupIdentity {
+				accountID, identity, err := awsutils.GetAccountDetails(handler.Config)
+				if err != nil {
+					return nil, err
+				}
+				acc = account{id: accountID, identity: identity}
+			}
+			accounts[key] = acc
+		}
+
+		route := Route{
+			Path:           handler.StagePath,
+			Methods:        handler.Methods,
+			StageVariables: handler.StageVariables,
+			RestAPIID:      handler.RestAPIID,
+			ResourceID:     handler.ResourceID,
+			AccountID:      acc.id,
+			Region:         handler.Config.Region,
+			Identity:       acc.identity,
+			ListenAddress:  handler.ListenAddress,
+		}
+
+		if withMethods {
+			details, err := describeMethods(&handler)
+			if err != nil {
+				return nil, err
+			}
+			route.MethodDetails = details
+		}
+
+		routes = append(routes, route)
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].ListenAddress != routes[j].ListenAddress {
+			return routes[i].ListenAddress < routes[j].ListenAddress
+		}
+		return routes[i].Path < routes[j].Path
+	})
+
+	return routes, nil
+}
+
+func describeMethods(handler *Handler) ([]MethodDetails, error) {
+	methods := append([]string(nil), handler.Methods...)
+	sort.Strings(methods)
+
+	details := make([]MethodDetails, 0, len(methods))
+	for _, method := range methods {
+		output, err := awsutils.DescribeMethod(handler.Config, handler.RestAPIID, handler.ResourceID, method)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't describe method %s %s: %w", method, handler.Path, err)
+		}
+
+		detail := MethodDetails{
+			Method:            method,
+			AuthorizationType: aws.ToString(output.AuthorizationType),
+			APIKeyRequired:    aws.ToBool(output.ApiKeyRequired),
+		}
+		if output.MethodIntegration != nil {
+			detail.IntegrationType = string(output.MethodIntegration.Type)
+			detail.IntegrationURI = aws.ToString(output.MethodIntegration.Uri)
+		}
+		details = append(details, detail)
+	}
+
+	return details, nil
+}
+
+// PrintMappings prints the routes of the handler mapping without calling AWS,
+// with the identity recorded when they loaded.
 func PrintMappings(handlerMapping map[*regexp.Regexp]Handler) error {
+	return WriteRoutes(os.Stdout, loadedRoutes(handlerMapping), RouteFormatTable)
+}
+
+// WriteRoutes writes the routes in the format. Tabular formats have a row per
+// method when the routes have method details.
+func WriteRoutes(w io.Writer, routes []Route, format RouteFormat) error {
+	switch format {
+	case RouteFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(routes)
+	case RouteFormatYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(routes); err != nil {
+			return err
+		}
+		return encoder.Close()
+	}
+
+	t := routesTable(routes, format != RouteFormatTable)
+	t.SetOutputMirror(w)
+
+	switch format {
+	case RouteFormatCSV:
+		t.RenderCSV()
+	case RouteFormatMarkdown:
+		t.RenderMarkdown()
+	default:
+		t.SetStyle(table.StyleLight)
+		t.Style().Options.SeparateRows = true
+		t.Render()
+	}
+
+	return nil
+}
+
+// routesTable returns a table with a row per route, or per method when the
+// routes have method details. Plain tables format lists and maps as text,
+// for CSV and Markdown.
+func routesTable(routes []Route, plain bool) table.Writer {
+	// Only show where routes are served when some gateway has its own address
+	withListenAddress := lo.SomeBy(routes, func(route Route) bool { return route.ListenAddress != "" })
+	withUpstream := lo.SomeBy(routes, func(route Route) bool { return route.Upstream != "" || route.Mock })
+	withMethods := lo.SomeBy(routes, func(route Route) bool { return len(route.MethodDetails) > 0 })
+
 	t := table.NewWriter()
-	t.SetOutputMirror(os.Stdout)
-	t.AppendHeader(table.Row{"Path", "Methods", "Stage Variables", "Rest API ID", "Resource ID", "Account ID", "Region", "Identity"})
+	header := table.Row{"Path", "Methods", "Stage Variables", "Rest API ID", "Resource ID", "Account ID", "Region", "Identity"}
+	if withMethods {
+		header = table.Row{"Path", "Method", "Authorization", "API Key", "Integration", "Integration URI", "Stage Variables", "Rest API ID", "Resource ID", "Account ID", "Region", "Identity"}
+	}
+	if withListenAddress {
+		header = append(table.Row{"Listen Address"}, header...)
+	}
+	if withUpstream {
+		header = append(header, "Upstream")
+	}
+	t.AppendHeader(header)
 	t.SetColumnConfigs([]table.ColumnConfig{
+		{Name: "Listen Address", AutoMerge: true},
+		{Name: "Path", AutoMerge: withMethods},
 		{Name: "Stage Variables", AutoMerge: true},
 		{Name: "Rest API ID", AutoMerge: true},
 		{Name: "Account ID", AutoMerge: true},
 		{Name: "Region", AutoMerge: true},
 		{Name: "Identity", WidthMax: 40, AutoMerge: true},
+		{Name: "Integration URI", WidthMax: 60},
 	})
 
-	for _, handler := range handlerMapping {
-		accountID, identity, err := awsutils.GetAccountDetails(handler.Config)
-		if err != nil {
-			return err
+	methods := func(route Route) any {
+		if !plain {
+			return route.Methods
 		}
+		return strings.Join(route.Methods, " ")
+	}
+	stageVariables := func(route Route) any {
+		if !plain {
+			return route.StageVariables
+		}
+		variables := lo.MapToSlice(route.StageVariables, func(key, value string) string { return key + "=" + value })
+		sort.Strings(variables)
+		return strings.Join(variables, " ")
+	}
 
-		t.AppendRow(table.Row{
-			handler.StagePath,
-			handler.Methods,
-			handler.StageVariables,
-			handler.RestAPIID,
-			handler.ResourceID,
-			accountID,
-			handler.Config.Region,
-			identity,
-		})
+	appendRow := func(route Route, columns ...any) {
+		row := append(table.Row{route.Path}, columns...)
+		row = append(row,
+			stageVariables(route),
+			route.RestAPIID,
+			route.ResourceID,
+			route.AccountID,
+			route.Region,
+			route.Identity,
+		)
+		if withListenAddress {
+			row = append(table.Row{route.ListenAddress}, row...)
+		}
+		if withUpstream {
+			row = append(row, lo.Ternary(route.Mock, "mock", route.Upstream))
+		}
+		t.AppendRow(row)
 	}
 
-	t.SetStyle(table.StyleLight)
-	t.Style().Options.SeparateRows = true
-	t.Render()
+	for _, route := range routes {
+		switch {
+		case !withMethods:
+			appendRow(route, methods(route))
+		case len(route.MethodDetails) == 0:
+			appendRow(route, methods(route), "", "", "", "")
+		default:
+			for _, method := range route.MethodDetails {
+				appendRow(route,
+					method.Method,
+					method.AuthorizationType,
+					lo.Ternary(method.APIKeyRequired, "required", ""),
+					method.IntegrationType,
+					method.IntegrationURI,
+				)
+			}
+		}
+	}
 
-	return nil
+	return t
 }
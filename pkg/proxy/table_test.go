@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRouteFormat(t *testing.T) {
+	t.Parallel()
+
+	format, err := ParseRouteFormat("Markdown")
+	require.NoError(t, err)
+	assert.Equal(t, RouteFormatMarkdown, format)
+
+	_, err = ParseRouteFormat("xml")
+	require.EqualError(t, err, "invalid output format: must be one of table, json, yaml, csv, markdown")
+}
+
+func TestWriteRoutes(t *testing.T) {
+	t.Parallel()
+
+	routes := []Route{
+		{
+			Path:           "/dev/users/{id}",
+			Methods:        []string{"GET", "DELETE"},
+			StageVariables: map[string]string{"env": "dev", "backend": "users"},
+			RestAPIID:      "api",
+			ResourceID:     "res",
+			AccountID:      "123456789012",
+			Region:         "eu-west-1",
+			Identity:       "arn:aws:iam::123456789012:user/dev",
+			MethodDetails: []MethodDetails{
+				{Method: "DELETE", AuthorizationType: "AWS_IAM", APIKeyRequired: true, IntegrationType: "AWS_PROXY", IntegrationURI: "arn:aws:lambda"},
+				{Method: "GET", AuthorizationType: "NONE", IntegrationType: "HTTP_PROXY", IntegrationURI: "http://users.internal/{id}"},
+			},
+		},
+		{Path: "/dev/health", Methods: []string{"ANY"}, Mock: true},
+	}
+
+	tests := []struct {
+		format RouteFormat
+		exp    string
+	}{
+		{
+			format: RouteFormatCSV,
+			exp: `Path,Method,Authorization,API Key,Integration,Integration URI,Stage Variables,Rest API ID,Resource ID,Account ID,Region,Identity,Upstream
+/dev/users/{id},DELETE,AWS_IAM,required,AWS_PROXY,arn:aws:lambda,backend=users env=dev,api,res,123456789012,eu-west-1,arn:aws:iam::123456789012:user/dev,
+/dev/users/{id},GET,NONE,,HTTP_PROXY,http://users.internal/{id},backend=users env=dev,api,res,123456789012,eu-west-1,arn:aws:iam::123456789012:user/dev,
+/dev/health,ANY,,,,,,,,,,,mock
+`,
+		},
+		{
+			format: RouteFormatYAML,
+			exp: `- path: /dev/users/{id}
+  methods:
+    - GET
+    - DELETE
+  stage_variables:
+    backend: users
+    env: dev
+  rest_api_id: api
+  resource_id: res
+  account_id: "123456789012"
+  region: eu-west-1
+  identity: arn:aws:iam::123456789012:user/dev
+  method_details:
+    - method: DELETE
+      authorization_type: AWS_IAM
+      api_key_required: true
+      integration_type: AWS_PROXY
+      integration_uri: arn:aws:lambda
+    - method: GET
+      authorization_type: NONE
+      api_key_required: false
+      integration_type: HTTP_PROXY
+      integration_uri: http://users.internal/{id}
+- path: /dev/health
+  methods:
+    - ANY
+  rest_api_id: ""
+  resource_id: ""
+  account_id: ""
+  region: ""
+  identity: ""
+  mock: true
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			t.Parallel()
+
+			var out bytes.Buffer
+			require.NoError(t, WriteRoutes(&out, routes, tt.format))
+			assert.Equal(t, tt.exp, out.String())
+		})
+	}
+}
+
+func TestDescribeRoutesAccounts(t *testing.T) {
+	t.Parallel()
+
+	mapping := map[*regexp.Regexp]Handler{
+		regexp.MustCompile(`^/dev/users$`): {
+			StagePath: "/dev/users", RestAPIID: "abc123", Methods: []string{"GET"},
+			Config: aws.Config{Region: "eu-west-1"}, AccountID: "111111111111", Identity: "arn:aws:iam::111111111111:user/dev",
+		},
+		regexp.MustCompile(`^/prod/users$`): {
+			StagePath: "/prod/users", RestAPIID: "abc123", Methods: []string{"GET"}, ProfileName: "prod",
+			Config: aws.Config{Region: "eu-west-1"}, AccountID: "222222222222", Identity: "arn:aws:iam::222222222222:user/prod",
+		},
+		regexp.MustCompile(`^/us/users$`): {
+			StagePath: "/us/users", RestAPIID: "abc123", Methods: []string{"GET"},
+			Config: aws.Config{Region: "us-east-1"}, AccountID: "333333333333", Identity: "arn:aws:iam::333333333333:user/us",
+		},
+	}
+
+	// Gateways sharing the Rest API ID keep their own account
+	routes := loadedRoutes(mapping)
+	require.Len(t, routes, 3)
+	accounts := make(map[string]string, len(routes))
+	for _, route := range routes {
+		accounts[route.Path] = route.Region + " " + route.AccountID + " " + route.Identity
+	}
+	assert.Equal(t, map[string]string{
+		"/dev/users":  "eu-west-1 111111111111 arn:aws:iam::111111111111:user/dev",
+		"/prod/users": "eu-west-1 222222222222 arn:aws:iam::222222222222:user/prod",
+		"/us/users":   "us-east-1 333333333333 arn:aws:iam::333333333333:user/us",
+	}, accounts)
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/oscarbc96/agbridge/pkg/proxy"
+
+var tracer = otel.Tracer(tracerName)
+
+// startServerSpan starts the span covering a proxied request, continuing the
+// trace described by the incoming traceparent and tracestate headers.
+func startServerSpan(r *http.Request) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+	return tracer.Start(ctx, r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		),
+	)
+}
+
+func setSpanRoute(span trace.Span, method string, handler *Handler) {
+	span.SetName(method + " " + handler.StagePath)
+	span.SetAttributes(semconv.HTTPRoute(handler.StagePath))
+	switch {
+	case handler.Mock != nil:
+		span.SetAttributes(attribute.Bool("agbridge.mock", true))
+		return
+	case handler.Upstream != nil:
+		span.SetAttributes(attribute.String("agbridge.upstream", handler.Upstream.Redacted()))
+		return
+	case handler == adminRoute:
+		return
+	}
+	span.SetAttributes(
+		attribute.String("aws.apigateway.rest_api_id", handler.RestAPIID),
+		attribute.String("aws.apigateway.resource_id", handler.ResourceID),
+	)
+}
+
+func endServerSpan(span trace.Span, status int) {
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// startInvokeSpan starts the child span of a TestInvokeMethod call and returns
+// a copy of headers carrying its trace context, to be forwarded upstream.
+func startInvokeSpan(ctx context.Context, headers http.Header) (context.Context, trace.Span, http.Header) {
+	ctx, span := tracer.Start(ctx, "APIGateway.TestInvokeMethod",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("aws-api"),
+			semconv.RPCService("APIGateway"),
+			semconv.RPCMethod("TestInvokeMethod"),
+		),
+	)
+
+	headers = headers.Clone()
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(headers))
+
+	return ctx, span, headers
+}
+
+func endInvokeSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
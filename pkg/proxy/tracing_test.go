@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	spanExporter     = tracetest.NewInMemoryExporter()
+	setupTracingOnce sync.Once
+)
+
+// recordSpans installs a tracer provider recording the spans in memory. The
+// provider is global, tests tell their spans apart by trace ID.
+func recordSpans() {
+	setupTracingOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spanExporter)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+}
+
+// spansOf returns the ended spans of the trace, by name.
+func spansOf(traceID trace.TraceID) map[string]tracetest.SpanStub {
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range spanExporter.GetSpans() {
+		if span.SpanContext.TraceID() == traceID {
+			spans[span.Name] = span
+		}
+	}
+	return spans
+}
+
+func attributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	result := make(map[attribute.Key]attribute.Value, len(span.Attributes))
+	for _, attr := range span.Attributes {
+		result[attr.Key] = attr.Value
+	}
+	return result
+}
+
+// tracedRequest returns a request continuing a trace with a parent span
+// unique to the test.
+func tracedRequest(method, target string, n int) (*http.Request, trace.TraceID, trace.SpanID) {
+	traceID := trace.TraceID{0xa9, byte(n)}
+	spanID := trace.SpanID{0x5b, byte(n)}
+
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("Traceparent", fmt.Sprintf("00-%s-%s-01", traceID, spanID))
+	return r, traceID, spanID
+}
+
+func TestServerSpan(t *testing.T) {
+	recordSpans()
+
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("Traceparent")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(upstream.Close)
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	mock := &MockConfig{Path: "/mock"}
+	require.NoError(t, mock.parse("ok"))
+	mapping := map[*regexp.Regexp]Handler{
+		regexp.MustCompile(`^/mock$`):     {StagePath: "/mock", Path: "/mock", Methods: []string{anyMethod}, Mock: mock},
+		regexp.MustCompile(`^/upstream$`): {StagePath: "/upstream", Methods: []string{anyMethod}, Upstream: upstreamURL},
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		expName   string
+		expStatus int
+		expCode   codes.Code
+		expAttrs  map[attribute.Key]attribute.Value
+	}{
+		{
+			name:      "Mock",
+			path:      "/mock",
+			expName:   "GET /mock",
+			expStatus: http.StatusOK,
+			expCode:   codes.Unset,
+			expAttrs:  map[attribute.Key]attribute.Value{"agbridge.mock": attribute.BoolValue(true), "http.route": attribute.StringValue("/mock")},
+		},
+		{
+			name:      "Upstream",
+			path:      "/upstream",
+			expName:   "GET /upstream",
+			expStatus: http.StatusBadGateway,
+			expCode:   codes.Error,
+			expAttrs:  map[attribute.Key]attribute.Value{"agbridge.upstream": attribute.StringValue(upstream.URL), "http.route": attribute.StringValue("/upstream")},
+		},
+		{
+			name:      "No route",
+			path:      "/missing",
+			expName:   "GET",
+			expStatus: http.StatusInternalServerError,
+			expCode:   codes.Error,
+			expAttrs:  map[attribute.Key]attribute.Value{"url.path": attribute.StringValue("/missing")},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, traceID, parentID := tracedRequest(http.MethodGet, tt.path, i)
+			serveInstrumented(httptest.NewRecorder(), r, mapping)
+
+			span, ok := spansOf(traceID)[tt.expName]
+			require.True(t, ok, "missing span %s", tt.expName)
+
+			// The span continues the trace of the caller
+			assert.Equal(t, parentID, span.Parent.SpanID())
+			assert.True(t, span.Parent.IsRemote())
+			assert.Equal(t, trace.SpanKindServer, span.SpanKind)
+			assert.Equal(t, tt.expCode, span.Status.Code)
+
+			attrs := attributes(span)
+			assert.Equal(t, attribute.StringValue(http.MethodGet), attrs["http.request.method"])
+			assert.Equal(t, attribute.IntValue(tt.expStatus), attrs["http.response.status_code"])
+			for key, value := range tt.expAttrs {
+				assert.Equal(t, value, attrs[key], key)
+			}
+		})
+	}
+
+	// The upstream continues the trace of the server span
+	_, traceID, _ := tracedRequest(http.MethodGet, "/upstream", 1)
+	assert.Equal(t, fmt.Sprintf("00-%s-%s-01", traceID, spansOf(traceID)["GET /upstream"].SpanContext.SpanID()), upstreamTraceparent)
+}
+
+func TestInvokeSpan(t *testing.T) {
+	recordSpans()
+
+	var forwarded map[string][]string
+	awsCfg := newFakeAPIGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			MultiValueHeaders map[string][]string `json:"multiValueHeaders"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err == nil {
+			forwarded = input.MultiValueHeaders
+		}
+		respondTestInvoke(testInvokeResponse{Status: http.StatusOK})(w, r)
+	})
+
+	r, traceID, _ := tracedRequest(http.MethodPost, "/dev/users", 100)
+	serveInstrumented(httptest.NewRecorder(), r, testInvokeMapping(awsCfg, "tracing"))
+
+	spans := spansOf(traceID)
+	server, ok := spans["POST /dev/users"]
+	require.True(t, ok)
+	invoke, ok := spans["APIGateway.TestInvokeMethod"]
+	require.True(t, ok)
+
+	attrs := attributes(server)
+	assert.Equal(t, attribute.StringValue("tracing"), attrs["aws.apigateway.rest_api_id"])
+	assert.Equal(t, attribute.StringValue("res123"), attrs["aws.apigateway.resource_id"])
+	assert.Equal(t, attribute.StringValue("/dev/users"), attrs["http.route"])
+	assert.Equal(t, attribute.IntValue(http.StatusOK), attrs["http.response.status_code"])
+
+	// The TestInvokeMethod call is a child of the server span, and its trace
+	// context is forwarded to the integration
+	assert.Equal(t, server.SpanContext.SpanID(), invoke.Parent.SpanID())
+	assert.Equal(t, trace.SpanKindClient, invoke.SpanKind)
+	invokeAttrs := attributes(invoke)
+	assert.Equal(t, attribute.StringValue("aws-api"), invokeAttrs["rpc.system"])
+	assert.Equal(t, attribute.StringValue("TestInvokeMethod"), invokeAttrs["rpc.method"])
+	assert.Equal(t, []string{fmt.Sprintf("00-%s-%s-01", traceID, invoke.SpanContext.SpanID())}, forwarded["Traceparent"])
+}
+
+func TestEndInvokeSpanError(t *testing.T) {
+	recordSpans()
+
+	ctx, parent := otel.Tracer(tracerName).Start(t.Context(), "parent")
+	_, span, headers := startInvokeSpan(ctx, http.Header{"Accept": {"application/json"}})
+	endInvokeSpan(span, fmt.Errorf("throttled"))
+	parent.End()
+
+	spans := spansOf(parent.SpanContext().TraceID())
+	invoke := spans["APIGateway.TestInvokeMethod"]
+	assert.Equal(t, codes.Error, invoke.Status.Code)
+	assert.Equal(t, "throttled", invoke.Status.Description)
+	require.Len(t, invoke.Events, 1)
+	assert.Equal(t, "exception", invoke.Events[0].Name)
+
+	// The headers of the request are copied, not changed
+	assert.Equal(t, "application/json", headers.Get("Accept"))
+	assert.NotEmpty(t, headers.Get("Traceparent"))
+}
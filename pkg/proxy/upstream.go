@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/oscarbc96/agbridge/pkg/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// fallbackPath is the route of the fallback upstream in route tables.
+const fallbackPath = "/*"
+
+// RouteConfig sends the requests matching a path, and optionally a set of
+// methods, to an HTTP upstream instead of API Gateway.
+type RouteConfig struct {
+	// Path is an API Gateway path template, like `/users/{id}` or
+	// `/api/{proxy+}`.
+	Path string `yaml:"path" json:"path"`
+	// Methods defaults to any method.
+	Methods  []string `yaml:"methods" json:"methods,omitempty"`
+	Upstream string   `yaml:"upstream" json:"upstream"`
+	// StripPrefix is removed from the request path before it is sent
+	// upstream.
+	StripPrefix   string `yaml:"strip_prefix" json:"strip_prefix,omitempty"`
+	ListenAddress string `yaml:"listen_address" json:"listen_address,omitempty"`
+}
+
+func (rc RouteConfig) validate() error {
+	if !strings.HasPrefix(rc.Path, "/") {
+		return fmt.Errorf("route path %q must start with /", rc.Path)
+	}
+	if _, err := parseUpstream(rc.Upstream); err != nil {
+		return fmt.Errorf("invalid upstream for route %s: %w", rc.Path, err)
+	}
+	if rc.ListenAddress != "" {
+		if err := ValidateListenAddress(rc.ListenAddress); err != nil {
+			return fmt.Errorf("invalid listen address format for route %s: %w", rc.Path, err)
+		}
+	}
+	return nil
+}
+
+// redacted returns a copy of the route without the password of its upstream.
+func (rc RouteConfig) redacted() RouteConfig {
+	rc.Upstream = redactURL(rc.Upstream)
+	return rc
+}
+
+// redactURL masks the password of the URL, if any.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+func parseUpstream(upstream string) (*url.URL, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("upstream must be an absolute http or https URL")
+	}
+	return u, nil
+}
+
+// localHandlers returns the handlers of the mocks, the route overrides and
+// the fallback upstream.
+func (c *Config) localHandlers() ([]Handler, error) {
+	handlers, err := c.mockHandlers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, route := range c.Routes {
+		upstream, err := parseUpstream(route.Upstream)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream for route %s: %w", route.Path, err)
+		}
+
+		methods := route.Methods
+		if len(methods) == 0 {
+			methods = []string{anyMethod}
+		}
+
+		handlers = append(handlers, Handler{
+			StagePath:     route.Path,
+			Path:          route.Path,
+			Methods:       methods,
+			ListenAddress: route.ListenAddress,
+			CORS:          c.CORS,
+			Upstream:      upstream,
+			StripPrefix:   route.StripPrefix,
+		})
+	}
+
+	if c.FallbackUpstream != "" {
+		upstream, err := parseUpstream(c.FallbackUpstream)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fallback upstream: %w", err)
+		}
+
+		handlers = append(handlers, Handler{
+			StagePath: fallbackPath,
+			Path:      fallbackPath,
+			Methods:   []string{anyMethod},
+			CORS:      c.CORS,
+			Upstream:  upstream,
+			Fallback:  true,
+		})
+	}
+
+	return handlers, nil
+}
+
+// serveUpstream proxies the request to the upstream of the handler and
+// returns the response status.
+func serveUpstream(w http.ResponseWriter, r *http.Request, handler *Handler) int {
+	rec := &responseRecorder{ResponseWriter: w}
+
+	reverseProxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if handler.StripPrefix != "" {
+				pr.Out.URL.Path = strings.TrimPrefix(pr.Out.URL.Path, handler.StripPrefix)
+				pr.Out.URL.RawPath = ""
+			}
+			pr.SetURL(handler.Upstream)
+			pr.SetXForwarded()
+			otel.GetTextMapPropagator().Inject(pr.Out.Context(), propagation.HeaderCarrier(pr.Out.Header))
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			// CORS headers set by agbridge take precedence over the upstream ones
+			if handler.CORS != nil && r.Header.Get("Origin") != "" {
+				for key := range resp.Header {
+					if isCORSHeader(key) {
+						resp.Header.Del(key)
+					}
+				}
+			}
+			// The request ID of the proxy replaces the one of the upstream
+			if w.Header().Get(RequestIDHeader) != "" {
+				resp.Header.Del(RequestIDHeader)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			writeError(w, r, http.StatusBadGateway, err, "Error calling upstream")
+		},
+	}
+
+	log.FromContext(r.Context()).Debug("Sending request to upstream",
+		log.String("upstream", handler.Upstream.Redacted()),
+		log.String("method", r.Method),
+		log.String("path", r.URL.Path),
+	)
+
+	reverseProxy.ServeHTTP(rec, r)
+
+	return rec.statusCode()
+}